@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/mibk/syd/core"
+)
+
+const usage = `usage: syd [flags] [[+addr] file ...]
+
+A file named - reads the standard input into the +stdin window.
+A file may be preceded by +addr or followed by :line[:col]
+to select the address addr in it, e.g. +42, +/func main/.
+
+If syd is already running, the files are opened in it.
+
+With -e, syd edits a single file and exits once its window is closed,
+as expected of $EDITOR. The exit status is 1 if the file wasn't saved.
+
+Flags:
+`
+
+// options holds the parsed command-line arguments.
+type options struct {
+	columns  int
+	load     string // dump file to load
+	readOnly bool
+	version  bool
+	files    []fileArg
+
+	newInstance bool // don't open the files in a running syd
+	wait        bool // wait until the files are closed
+	edit        bool // edit a single file as $EDITOR
+}
+
+type fileArg struct {
+	name string // "-" for the standard input
+	addr string // address to select, if any
+}
+
+// parseArgs parses the command-line arguments args, which don't
+// include the program name. Usage and flag errors are written to w.
+func parseArgs(args []string, w io.Writer) (*options, error) {
+	opts := new(options)
+	fs := flag.NewFlagSet("syd", flag.ContinueOnError)
+	fs.SetOutput(w)
+	fs.IntVar(&opts.columns, "c", 1, "open `n` columns")
+	fs.StringVar(&opts.load, "l", "", "load the layout from the dump `file`")
+	fs.BoolVar(&opts.readOnly, "r", false, "open the files read-only")
+	fs.BoolVar(&opts.newInstance, "n", false, "start a new syd even if one is running")
+	fs.BoolVar(&opts.wait, "w", false, "wait until the files are closed in the running syd")
+	fs.BoolVar(&opts.edit, "e", false, "edit a single file and exit when it is closed")
+	fs.BoolVar(&opts.version, "version", false, "print the version and exit")
+	fs.Usage = func() {
+		fmt.Fprint(w, usage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if opts.columns < 1 {
+		return nil, errors.New("number of columns must be positive")
+	}
+
+	var addr string
+	for _, a := range fs.Args() {
+		if strings.HasPrefix(a, "+") {
+			if addr != "" {
+				return nil, fmt.Errorf("address +%s not followed by a file", addr)
+			}
+			addr = a[1:]
+			if addr == "" {
+				return nil, errors.New("empty address")
+			}
+			continue
+		}
+		name := a
+		if addr == "" {
+			name, addr = core.SplitLineSuffix(a)
+		}
+		opts.files = append(opts.files, fileArg{name: name, addr: addr})
+		addr = ""
+	}
+	if addr != "" {
+		return nil, fmt.Errorf("address +%s not followed by a file", addr)
+	}
+	if opts.load != "" && len(opts.files) > 0 {
+		return nil, errors.New("cannot open files when loading a dump")
+	}
+	if opts.edit {
+		if len(opts.files) != 1 {
+			return nil, errors.New("-e requires exactly one file")
+		}
+		opts.wait = true
+	}
+	return opts, nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"testing"
+)
+
+func TestParseArgs(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{nil, `c=1 l="" r=false [] `},
+		{[]string{"-"}, `c=1 l="" r=false [{- }] `},
+		{[]string{"-c", "2", "a", "b", "c"}, `c=2 l="" r=false [{a } {b } {c }] `},
+		{[]string{"-r", "+12", "a.go", "b.go"}, `c=1 l="" r=true [{a.go 12} {b.go }] `},
+		{[]string{"+/func main/", "main.go"}, `c=1 l="" r=false [{main.go /func main/}] `},
+		{[]string{"main.go:12", "x.go:3:14"}, `c=1 l="" r=false [{main.go 12} {x.go 3:14}] `},
+		{[]string{"a:b", "c:", "+5", "d:7"}, `c=1 l="" r=false [{a:b } {c: } {d:7 5}] `},
+		{[]string{"-l", "syd.dump"}, `c=1 l="syd.dump" r=false [] `},
+		{[]string{"-n", "-w", "a"}, `c=1 l="" r=false [{a }] new wait `},
+		{[]string{"-e", "COMMIT_EDITMSG"}, `c=1 l="" r=false [{COMMIT_EDITMSG }] wait edit `},
+		{[]string{"--version"}, `c=1 l="" r=false [] version`},
+	}
+
+	for _, tt := range tests {
+		opts, err := parseArgs(tt.args, ioutil.Discard)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.args, err)
+			continue
+		}
+		got := fmt.Sprintf("c=%d l=%q r=%v %v ", opts.columns, opts.load, opts.readOnly, opts.files)
+		if opts.newInstance {
+			got += "new "
+		}
+		if opts.wait {
+			got += "wait "
+		}
+		if opts.edit {
+			got += "edit "
+		}
+		if opts.version {
+			got += "version"
+		}
+		if got != tt.want {
+			t.Errorf("%q:\ngot:  %s\nwant: %s", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestParseArgsErrors(t *testing.T) {
+	tests := []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"-c", "0"}, "number of columns must be positive"},
+		{[]string{"-x"}, "flag provided but not defined: -x"},
+		{[]string{"a", "+5"}, "address +5 not followed by a file"},
+		{[]string{"+"}, "empty address"},
+		{[]string{"-l", "d", "a"}, "cannot open files when loading a dump"},
+		{[]string{"-e"}, "-e requires exactly one file"},
+		{[]string{"-e", "a", "b"}, "-e requires exactly one file"},
+	}
+
+	for _, tt := range tests {
+		_, err := parseArgs(tt.args, ioutil.Discard)
+		if err == nil {
+			t.Errorf("%q: expected error", tt.args)
+			continue
+		}
+		if got := err.Error(); got != tt.err {
+			t.Errorf("%q: got %q, want %q", tt.args, got, tt.err)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// addr evaluates the address s in the text and returns the range
+// it denotes. The address is either a simple address, or a range
+// a1,a2 spanning from the beginning of a1 to the end of a2, where
+// a1 defaults to 0 and a2 to $. A regular expression in a2 is
+// searched for after a1. The simple addresses are:
+//
+//	n	the n-th line
+//	n:c	the c-th character on the n-th line
+//	#n	the empty string after the n-th character
+//	/re/	the first match of the regular expression
+//		after the current selection
+//	.	the current selection
+//	0, $	the beginning and the end of the text
+//
+// Lines and characters on a line are numbered from 1.
+func (t *Text) addr(s string) (q0, q1 int64, err error) {
+	i := rangeComma(s)
+	if i == -1 {
+		return t.simpleAddr(s, t.q1, true)
+	}
+	if a1 := s[:i]; a1 != "" {
+		if q0, q1, err = t.simpleAddr(a1, t.q1, false); err != nil {
+			return 0, 0, err
+		}
+	}
+	a2 := s[i+1:]
+	if a2 == "" {
+		return q0, t.buf.End(), nil
+	}
+	_, r1, err := t.simpleAddr(a2, q1, false)
+	if err != nil {
+		return 0, 0, err
+	}
+	if r1 < q0 {
+		return 0, 0, fmt.Errorf("addresses out of order in %q", s)
+	}
+	return q0, r1, nil
+}
+
+// rangeComma returns the index of the comma separating the
+// addresses of a range in s, or -1.
+func rangeComma(s string) int {
+	inRe := false
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '/':
+			inRe = !inRe
+		case ',':
+			if !inRe {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// splitAddr splits the address prefix from a command operating
+// on the body, such as ,|gofmt or /func/,/^}/|sort. If the command
+// has no address, addr is empty.
+func splitAddr(command string) (addr, cmd string) {
+	inRe := false
+	for i := 0; i < len(command); i++ {
+		c := command[i]
+		switch {
+		case inRe && c == '\\':
+			i++
+		case c == '/':
+			inRe = !inRe
+		case inRe:
+		case c == '|' || c == '<' || c == '>':
+			return command[:i], command[i:]
+		case strings.IndexByte("0123456789#$.,:", c) == -1:
+			return "", command
+		}
+	}
+	return "", command
+}
+
+// simpleAddr evaluates the simple address s. A regular expression
+// is searched for from the position from, wrapping around if wrap
+// is true.
+func (t *Text) simpleAddr(s string, from int64, wrap bool) (q0, q1 int64, err error) {
+	switch {
+	case s == "":
+		return 0, 0, errors.New("empty address")
+	case s == ".":
+		return t.q0, t.q1, nil
+	case s == "$":
+		q := t.buf.End()
+		return q, q, nil
+	case s[0] == '#':
+		n, err := strconv.ParseInt(s[1:], 10, 64)
+		if err != nil || n < 0 {
+			return 0, 0, fmt.Errorf("bad character address %q", s)
+		}
+		if end := t.buf.End(); n > end {
+			return 0, 0, fmt.Errorf("address %s out of range", s)
+		}
+		return n, n, nil
+	case s[0] == '/':
+		re := strings.TrimSuffix(s[1:], "/")
+		rx, err := regexp.Compile("(?m)" + re)
+		if err != nil {
+			return 0, 0, err
+		}
+		q0, q1, ok := t.findForward(rx, from, wrap)
+		if !ok {
+			return 0, 0, fmt.Errorf("no match for %q", re)
+		}
+		return q0, q1, nil
+	}
+
+	line, col := s, ""
+	if i := strings.IndexByte(s, ':'); i != -1 {
+		line, col = s[:i], s[i+1:]
+	}
+	n, err := strconv.ParseInt(line, 10, 64)
+	if err != nil || n < 0 {
+		return 0, 0, fmt.Errorf("bad address %q", s)
+	}
+	q0, q1, err = t.line(n)
+	if err != nil || col == "" {
+		return q0, q1, err
+	}
+	c, err := strconv.ParseInt(col, 10, 64)
+	if err != nil || c < 1 {
+		return 0, 0, fmt.Errorf("bad column in address %q", s)
+	}
+	q := q0 + c - 1
+	if q > q1 {
+		q = q1
+	}
+	return q, q, nil
+}
+
+// line returns the range of the n-th line, including the final
+// newline. Line 0 is the empty string at the beginning.
+func (t *Text) line(n int64) (q0, q1 int64, err error) {
+	if n == 0 {
+		return 0, 0, nil
+	}
+	l := int64(1)
+	for q := int64(0); ; q++ {
+		r := t.readRuneAt(q)
+		if r == EOF {
+			if l == n && (q > q0 || n == 1) {
+				return q0, q, nil
+			}
+			return 0, 0, fmt.Errorf("line %d out of range", n)
+		}
+		if r == '\n' {
+			if l == n {
+				return q0, q + 1, nil
+			}
+			l++
+			q0 = q + 1
+		}
+	}
+}
+
+// findForward returns the first match of rx at or after q. If wrap
+// is true, the search continues from the beginning of the text.
+func (t *Text) findForward(rx *regexp.Regexp, q int64, wrap bool) (q0, q1 int64, ok bool) {
+	buf, ok := t.buf.(*UndoBuffer)
+	if !ok {
+		return 0, 0, false
+	}
+	starts := []int64{q}
+	if wrap && q > 0 {
+		starts = append(starts, 0)
+	}
+	for _, from := range starts {
+		r, off := buf.RuneReaderFrom(from)
+		if loc := rx.FindReaderIndex(r); loc != nil {
+			q0, q1 := buf.FindRange(off+int64(loc[0]), int64(loc[1]-loc[0]))
+			return q0, q1, true
+		}
+	}
+	return 0, 0, false
+}
+
+// SplitLineSuffix splits file:line and file:line:col, as printed
+// by compilers, into the filename and the address.
+func SplitLineSuffix(s string) (name, addr string) {
+	name = s
+	for i := 0; i < 2; i++ {
+		j := strings.LastIndexByte(name, ':')
+		if j <= 0 {
+			break
+		}
+		if _, err := strconv.ParseUint(name[j+1:], 10, 64); err != nil {
+			break
+		}
+		name = name[:j]
+	}
+	if name == s {
+		return s, ""
+	}
+	return name, s[len(name)+1:]
+}
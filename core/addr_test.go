@@ -0,0 +1,73 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/mibk/syd/undo"
+)
+
+func TestAddr(t *testing.T) {
+	const text = "package main\n\nfunc main() {\n\tprintln(1)\n}\n\nfunc f() {}\n"
+	tests := []struct {
+		addr string
+		want string
+		err  string
+	}{
+		{addr: "1", want: "package main\n"},
+		{addr: "3:6", want: ""},
+		{addr: "#8", want: ""},
+		{addr: ".", want: "main"},
+		{addr: "/func/", want: "func"},
+		{addr: ",", want: text},
+		{addr: "0,$", want: text},
+		{addr: "3,4", want: "func main() {\n\tprintln(1)\n"},
+		{addr: "3,", want: text[14:]},
+		{addr: ",2", want: "package main\n\n"},
+		{addr: "/func/,/^}/", want: "func main() {\n\tprintln(1)\n}"},
+		{addr: "/\\/,/,/}/", err: `no match for "\\/,"`},
+		{addr: "#20,#40", want: text[20:40]},
+		{addr: "5,3", err: `addresses out of order in "5,3"`},
+		{addr: "9", err: "line 9 out of range"},
+		{addr: "x", err: `bad address "x"`},
+	}
+	for _, tt := range tests {
+		buf := NewUndoBuffer(undo.NewBuffer([]byte(text)))
+		tx := newText(nil, buf)
+		tx.Select(8, 12)
+		q0, q1, err := tx.addr(tt.addr)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%s: got error %v, want %s", tt.addr, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.addr, err)
+			continue
+		}
+		if got := tx.SelectionToString(q0, q1); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestSplitAddr(t *testing.T) {
+	tests := []struct {
+		command   string
+		addr, cmd string
+	}{
+		{",|gofmt", ",", "|gofmt"},
+		{"/func/,/^}/|sort", "/func/,/^}/", "|sort"},
+		{`/a\/|b/>wc`, `/a\/|b/`, ">wc"},
+		{"12<date", "12", "<date"},
+		{"|sort", "", "|sort"},
+		{"gofmt", "", "gofmt"},
+		{"/bin/ls|sort", "", "/bin/ls|sort"},
+	}
+	for _, tt := range tests {
+		addr, cmd := splitAddr(tt.command)
+		if addr != tt.addr || cmd != tt.cmd {
+			t.Errorf("%s: got %q %q, want %q %q", tt.command, addr, cmd, tt.addr, tt.cmd)
+		}
+	}
+}
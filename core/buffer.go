@@ -1,7 +1,9 @@
 package core
 
 import (
+	"bufio"
 	"io"
+	"sort"
 	"unicode/utf8"
 
 	"github.com/mibk/syd/undo"
@@ -14,6 +16,62 @@ type Buffer interface {
 	End() (q int64)
 }
 
+// Bytes that are not part of valid UTF-8 sequences are represented
+// by runes above EOF so that they can be told apart from a genuine
+// utf8.RuneError and written back unchanged.
+const rawByteBase = EOF + 1
+
+// RawByte reports whether r represents an invalid byte and returns it.
+func RawByte(r rune) (b byte, ok bool) {
+	if r < rawByteBase || r > rawByteBase+0xFF {
+		return 0, false
+	}
+	return byte(r - rawByteBase), true
+}
+
+func rawByteRune(b byte) rune { return rawByteBase + rune(b) }
+
+// RuneLen returns the number of bytes required to encode r.
+func RuneLen(r rune) int {
+	if _, ok := RawByte(r); ok {
+		return 1
+	}
+	return utf8.RuneLen(r)
+}
+
+// decodeRune is like utf8.DecodeRune, but it returns invalid bytes
+// as raw byte runes.
+func decodeRune(b []byte) (rune, int) {
+	r, size := utf8.DecodeRune(b)
+	if r == utf8.RuneError && size == 1 {
+		r = rawByteRune(b[0])
+	}
+	return r, size
+}
+
+// runesOf converts s to runes, keeping invalid bytes as raw byte runes.
+func runesOf(s string) []rune {
+	runes := make([]rune, 0, len(s))
+	for len(s) > 0 {
+		r, size := utf8.DecodeRuneInString(s)
+		if r == utf8.RuneError && size == 1 {
+			r = rawByteRune(s[0])
+		}
+		runes = append(runes, r)
+		s = s[size:]
+	}
+	return runes
+}
+
+// appendRune appends the encoding of r, as decoded by decodeRune,
+// to b.
+func appendRune(b []byte, r rune) []byte {
+	if c, ok := RawByte(r); ok {
+		return append(b, c)
+	}
+	return append(b, string(r)...)
+}
+
 type BasicBuffer struct {
 	runes []rune
 }
@@ -24,11 +82,11 @@ func (bb *BasicBuffer) ReadRuneAt(q int64) (r rune, size int, err error) {
 		return 0, 0, io.EOF
 	}
 	r = bb.runes[i]
-	return r, utf8.RuneLen(r), nil
+	return r, RuneLen(r), nil
 }
 
 func (bb *BasicBuffer) Insert(q int64, s string) {
-	bb.runes = append(bb.runes[:q], append([]rune(s), bb.runes[q:]...)...)
+	bb.runes = append(bb.runes[:q], append(runesOf(s), bb.runes[q:]...)...)
 }
 
 func (bb *BasicBuffer) Delete(q0, q1 int64) {
@@ -42,9 +100,32 @@ type UndoBuffer struct {
 	offset int64 // offset in bytes
 	pos    int64 // position in runes
 
+	// marks remember the offsets of every markInterval-th rune
+	// so that seeking in large files doesn't require reading them
+	// from the beginning.
+	marks []mark
+
+	// journal, if not nil, records all the operations
+	journal *journal
+	// startJournal, if not nil, is called to create the journal
+	// before the first change is recorded.
+	startJournal func() *journal
+
 	rb [4]byte // rune buffer
 }
 
+type mark struct {
+	pos, offset int64
+}
+
+const (
+	markInterval = 1 << 14
+
+	// Seeking backwards by at most maxStepBack runes is done rune
+	// by rune rather than by finding the nearest mark.
+	maxStepBack = 256
+)
+
 func NewUndoBuffer(buf *undo.Buffer) *UndoBuffer {
 	return &UndoBuffer{
 		Buffer: buf,
@@ -52,55 +133,107 @@ func NewUndoBuffer(buf *undo.Buffer) *UndoBuffer {
 }
 
 func (b *UndoBuffer) ReadRuneAt(pos int64) (r rune, size int, err error) {
-	if pos < b.pos {
-		b.offset = 0
-		b.pos = 0
+	if err := b.seek(pos); err != nil {
+		return 0, 0, err
 	}
-	for {
-		r, s, err := b.readRuneAtByteOffset(b.offset)
-		if err != nil {
-			return 0, 0, err
-		}
-		b.offset += int64(s)
-		b.pos++
-		if pos == b.pos-1 {
-			return r, s, nil
-		}
+	r, size, err = b.readRuneAtByteOffset(b.offset)
+	if err != nil {
+		return 0, 0, err
 	}
+	b.advance(size)
+	return r, size, nil
 }
 
 // RuneReaderFrom returns an io.RuneReader and the offset in bytes
-// that corresponds to q.
+// that corresponds to q. The reader reads the buffer directly,
+// so it mustn't be used once the buffer is modified.
 func (b *UndoBuffer) RuneReaderFrom(q int64) (r io.RuneReader, off int64) {
 	off = b.setPos(q)
-	return &posRuneReader{b: b, q: q}, off
+	sr := io.NewSectionReader(b.Buffer, off, b.Size()-off)
+	return &byteRuneReader{bufio.NewReaderSize(sr, 1<<16)}, off
+}
+
+// SectionReader returns a reader of the bytes of the runes from q0
+// to q1. It reads a snapshot of the buffer, so it can be used by other
+// goroutines even when the buffer is modified in the meantime. The
+// snapshot shares the content of the window, which must stay open
+// while it's read.
+func (b *UndoBuffer) SectionReader(q0, q1 int64) *io.SectionReader {
+	off0 := b.setPos(q0)
+	off1 := b.setPos(q1)
+	return io.NewSectionReader(b.Buffer.Snapshot(), off0, off1-off0)
 }
 
 func (b *UndoBuffer) Insert(q int64, s string) {
 	b.setPos(q)
+	b.truncateMarks(b.offset)
+	b.ensureJournal()
+	if b.journal != nil {
+		b.journal.insert(b.offset, s)
+	}
 	b.Buffer.Insert(b.offset, []byte(s))
 }
 
 func (b *UndoBuffer) Delete(q0, q1 int64) {
 	var size int64
 	offset := b.setPos(q0)
-	for l := q1 - q0; l > 0; l-- {
-		_, s, err := b.ReadRuneAt(q0)
+	for q := q0; q < q1; q++ {
+		_, s, err := b.ReadRuneAt(q)
 		if err == io.EOF {
-			return
+			break
 		} else if err != nil {
 			panic(err)
 		}
 		size += int64(s)
-		q0++
+	}
+	b.pos, b.offset = q0, offset
+	b.truncateMarks(offset)
+	b.ensureJournal()
+	if b.journal != nil {
+		b.journal.delete(offset, size)
 	}
 	if err := b.Buffer.Delete(offset, size); err != nil {
 		panic(err)
 	}
 }
 
-func (b *UndoBuffer) Undo() (q0, q1 int64) { return b.FindRange(b.Buffer.Undo()) }
-func (b *UndoBuffer) Redo() (q0, q1 int64) { return b.FindRange(b.Buffer.Redo()) }
+func (b *UndoBuffer) Undo() (q0, q1 int64) {
+	b.record(opUndo)
+	off, n := b.Buffer.Undo()
+	b.reset()
+	return b.FindRange(off, n)
+}
+
+func (b *UndoBuffer) Redo() (q0, q1 int64) {
+	b.record(opRedo)
+	off, n := b.Buffer.Redo()
+	b.reset()
+	return b.FindRange(off, n)
+}
+
+func (b *UndoBuffer) Commit() {
+	b.record(opCommit)
+	b.Buffer.Commit()
+}
+
+func (b *UndoBuffer) Clean() {
+	b.record(opClean)
+	b.Buffer.Clean()
+}
+
+// ensureJournal creates the journal before the first change,
+// if it's to be journaled.
+func (b *UndoBuffer) ensureJournal() {
+	if b.journal == nil && b.startJournal != nil {
+		b.journal, b.startJournal = b.startJournal(), nil
+	}
+}
+
+func (b *UndoBuffer) record(op byte) {
+	if b.journal != nil {
+		b.journal.op(op)
+	}
+}
 
 func (b *UndoBuffer) FindRange(off, n int64) (q0, q1 int64) {
 	if off == -1 {
@@ -112,39 +245,81 @@ func (b *UndoBuffer) FindRange(off, n int64) (q0, q1 int64) {
 }
 
 func (b *UndoBuffer) End() int64 {
-	p := b.pos
-	for ; ; p++ {
-		if _, _, err := b.ReadRuneAt(p); err != nil {
-			break
+	for {
+		_, size, err := b.readRuneAtByteOffset(b.offset)
+		if err != nil {
+			return b.pos
 		}
+		b.advance(size)
 	}
-	return p
 }
 
-func (b *UndoBuffer) setPos(pos int64) (offset int64) {
-	if pos < b.pos {
-		b.offset = 0
-		b.pos = 0
+// reset forgets all the known positions as the content of the
+// buffer might have changed arbitrarily.
+func (b *UndoBuffer) reset() {
+	b.offset, b.pos = 0, 0
+	b.marks = b.marks[:0]
+}
+
+// truncateMarks forgets the marks past the offset off.
+func (b *UndoBuffer) truncateMarks(off int64) {
+	i := sort.Search(len(b.marks), func(i int) bool {
+		return b.marks[i].offset > off
+	})
+	b.marks = b.marks[:i]
+}
+
+// seek moves the cursor to the rune at pos. It returns io.EOF
+// if pos is beyond the end of the buffer.
+func (b *UndoBuffer) seek(pos int64) error {
+	switch {
+	case pos < b.pos && b.pos-pos <= maxStepBack:
+		for b.pos > pos {
+			b.stepBack()
+		}
+		return nil
+	case pos < b.pos, pos-b.pos > markInterval:
+		i := sort.Search(len(b.marks), func(i int) bool {
+			return b.marks[i].pos > pos
+		})
+		if i > 0 && (pos < b.pos || b.marks[i-1].pos > b.pos) {
+			m := b.marks[i-1]
+			b.pos, b.offset = m.pos, m.offset
+		} else if pos < b.pos {
+			b.pos, b.offset = 0, 0
+		}
 	}
-	for {
-		if b.pos == pos {
-			return b.offset
+	for b.pos < pos {
+		_, size, err := b.readRuneAtByteOffset(b.offset)
+		if err != nil {
+			return err
 		}
-		b.advancePos()
+		b.advance(size)
 	}
+	return nil
+}
+
+func (b *UndoBuffer) setPos(pos int64) (offset int64) {
+	if err := b.seek(pos); err != nil {
+		panic(err)
+	}
+	return b.offset
 }
 
 func (b *UndoBuffer) setOffset(off int64) (pos int64) {
 	if off < b.offset {
-		b.offset = 0
-		b.pos = 0
-	}
-	for {
-		if b.offset >= off {
-			return b.pos
+		i := sort.Search(len(b.marks), func(i int) bool {
+			return b.marks[i].offset > off
+		})
+		b.pos, b.offset = 0, 0
+		if i > 0 {
+			b.pos, b.offset = b.marks[i-1].pos, b.marks[i-1].offset
 		}
+	}
+	for b.offset < off {
 		b.advancePos()
 	}
+	return b.pos
 }
 
 func (b *UndoBuffer) advancePos() {
@@ -152,8 +327,35 @@ func (b *UndoBuffer) advancePos() {
 	if err != nil {
 		panic(err)
 	}
+	b.advance(size)
+}
+
+// advance moves the cursor past the current rune of the given size.
+func (b *UndoBuffer) advance(size int) {
 	b.offset += int64(size)
 	b.pos++
+	if b.pos%markInterval == 0 {
+		if n := len(b.marks); n == 0 || b.marks[n-1].pos < b.pos {
+			b.marks = append(b.marks, mark{b.pos, b.offset})
+		}
+	}
+}
+
+// stepBack moves the cursor to the previous rune. Decoding the last
+// rune before the cursor gives the same rune boundary as decoding the
+// whole buffer from the beginning would: a start byte can never be
+// a part of another rune.
+func (b *UndoBuffer) stepBack() {
+	n := int64(utf8.UTFMax)
+	if b.offset < n {
+		n = b.offset
+	}
+	if _, err := b.Buffer.ReadAt(b.rb[:n], b.offset-n); err != nil {
+		panic(err)
+	}
+	_, size := utf8.DecodeLastRune(b.rb[:n])
+	b.offset -= int64(size)
+	b.pos--
 }
 
 func (b *UndoBuffer) readRuneAtByteOffset(off int64) (rune, int, error) {
@@ -161,17 +363,22 @@ func (b *UndoBuffer) readRuneAtByteOffset(off int64) (rune, int, error) {
 	if n == 0 && err != nil {
 		return 0, 0, err
 	}
-	r, s := utf8.DecodeRune(b.rb[:n])
+	r, s := decodeRune(b.rb[:n])
 	return r, s, nil
 }
 
-type posRuneReader struct {
-	b *UndoBuffer
-	q int64
+// byteRuneReader decodes runes from a bufio.Reader the same way
+// as UndoBuffer does.
+type byteRuneReader struct {
+	r *bufio.Reader
 }
 
-func (rr *posRuneReader) ReadRune() (r rune, size int, err error) {
-	r, size, err = rr.b.ReadRuneAt(rr.q)
-	rr.q++
-	return
+func (rr *byteRuneReader) ReadRune() (r rune, size int, err error) {
+	p, err := rr.r.Peek(utf8.UTFMax)
+	if len(p) == 0 {
+		return 0, 0, err
+	}
+	r, size = decodeRune(p)
+	rr.r.Discard(size)
+	return r, size, nil
 }
@@ -0,0 +1,197 @@
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/mibk/syd/undo"
+)
+
+func TestInvalidUTF8(t *testing.T) {
+	buf := NewUndoBuffer(undo.NewBuffer([]byte("a\xffb\xc3\xa9\xe2\x82")))
+	text := newText(nil, buf)
+
+	tests := []struct {
+		action func()
+		want   string
+		end    int64
+	}{
+		0: {func() {}, "a\xffb\xc3\xa9\xe2\x82", 6},
+		1: {func() { text.Select(1, 2); text.DeleteSel() }, "ab\xc3\xa9\xe2\x82", 5},
+		2: {func() { text.Select(0, 0); text.Insert("\xfe\xfe") }, "\xfe\xfeab\xc3\xa9\xe2\x82", 7},
+		3: {func() { text.Select(5, 7); text.Insert(text.SelectionToString(0, 2)) }, "\xfe\xfeab\xc3\xa9\xfe\xfe", 7},
+		4: {func() { text.Select(4, 5); text.Insert("\xef\xbf\xbd") }, "\xfe\xfeab\xef\xbf\xbd\xfe\xfe", 7},
+	}
+
+	for i, tt := range tests {
+		tt.action()
+		b, err := ioutil.ReadAll(io.NewSectionReader(buf, 0, buf.Size()))
+		if err != nil {
+			t.Fatalf("%d: unexpected error: %v", i, err)
+		}
+		if got := string(b); got != tt.want {
+			t.Errorf("%d: got %q, want %q", i, got, tt.want)
+		}
+		if got := text.SelectionToString(0, buf.End()); got != tt.want {
+			t.Errorf("%d: got selection %q, want %q", i, got, tt.want)
+		}
+		if got := buf.End(); got != tt.end {
+			t.Errorf("%d: got %d runes, want %d", i, got, tt.end)
+		}
+	}
+}
+
+func TestRawByteRunes(t *testing.T) {
+	r, _, err := NewUndoBuffer(undo.NewBuffer([]byte("\x80"))).ReadRuneAt(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, ok := RawByte(r); !ok || b != 0x80 {
+		t.Errorf("got %U, want raw byte 0x80", r)
+	}
+	if _, ok := RawByte('�'); ok {
+		t.Errorf("U+FFFD must not be a raw byte")
+	}
+	if _, ok := RawByte(EOF); ok {
+		t.Errorf("EOF must not be a raw byte")
+	}
+}
+
+func TestUndoBufferSeek(t *testing.T) {
+	var data []byte
+	var want []rune
+	for i := 0; len(want) < 3*markInterval+100; i++ {
+		switch i % 4 {
+		case 0:
+			data = append(data, "abc\n"...)
+			want = append(want, 'a', 'b', 'c', '\n')
+		case 1:
+			data = append(data, "žř"...)
+			want = append(want, 'ž', 'ř')
+		case 2:
+			data = append(data, "\xe2\x82"...)
+			want = append(want, rawByteRune(0xe2), rawByteRune(0x82))
+		case 3:
+			data = append(data, "€"...)
+			want = append(want, '€')
+		}
+	}
+	buf := NewUndoBuffer(undo.NewBuffer(data))
+
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		q := rnd.Int63n(int64(len(want)))
+		if i%3 == 0 && q > 10 {
+			// Also exercise stepping back.
+			buf.ReadRuneAt(q + 1)
+		}
+		r, _, err := buf.ReadRuneAt(q)
+		if err != nil {
+			t.Fatalf("%d: unexpected error: %v", q, err)
+		}
+		if r != want[q] {
+			t.Fatalf("%d: got %U, want %U", q, r, want[q])
+		}
+	}
+	if got, want := buf.End(), int64(len(want)); got != want {
+		t.Errorf("got end %d, want %d", got, want)
+	}
+	if _, _, err := buf.ReadRuneAt(int64(len(want))); err != io.EOF {
+		t.Errorf("got %v, want EOF", err)
+	}
+
+	// Modifications must invalidate the marks.
+	q := int64(2*markInterval + 5)
+	buf.Delete(10, 11)
+	buf.Insert(20, "\xc3")
+	want = append(want[:10], want[11:]...)
+	want = append(want[:20], append([]rune{rawByteRune(0xc3)}, want[20:]...)...)
+	for _, q := range []int64{q, 3, q + 1, markInterval + 1, 20, 21} {
+		r, _, err := buf.ReadRuneAt(q)
+		if err != nil {
+			t.Fatalf("%d: unexpected error: %v", q, err)
+		}
+		if r != want[q] {
+			t.Fatalf("%d: got %U, want %U", q, r, want[q])
+		}
+	}
+}
+
+// hugeFile returns a generated log file of about size bytes.
+func hugeFile(b *testing.B, size int) []byte {
+	b.Helper()
+	f, err := ioutil.TempFile("", "syd-huge")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	w := bufio.NewWriter(f)
+	for n, i := 0, 0; n < size; i++ {
+		k, _ := fmt.Fprintf(w, "2020-08-02 12:%02d:%02d INFO request %d served in %dms\n",
+			i/60%60, i%60, i, i%997)
+		n += k
+	}
+	if err := w.Flush(); err != nil {
+		b.Fatal(err)
+	}
+	con, err := Mmap(f)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { con.Close() })
+	return con.Bytes()
+}
+
+func BenchmarkHugeFileScroll(b *testing.B) {
+	data := hugeFile(b, 32<<20)
+	buf := NewUndoBuffer(undo.NewBuffer(data))
+	text := newText(nil, buf)
+	end := buf.End()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// Scroll up from a random place as the UI would.
+		q := end - int64(i*7919)%end
+		q = text.PrevNewLine(q, 3)
+		for p := q; p < q+4000; p++ {
+			text.readRuneAt(p)
+		}
+	}
+}
+
+func BenchmarkHugeFileSearch(b *testing.B) {
+	data := hugeFile(b, 32<<20)
+	buf := NewUndoBuffer(undo.NewBuffer(data))
+	rx := regexp.MustCompile(`request 9999999 `)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r, _ := buf.RuneReaderFrom(0)
+		if loc := rx.FindReaderIndex(r); loc != nil {
+			b.Fatalf("unexpected match at %d", loc[0])
+		}
+	}
+}
+
+func BenchmarkHugeFileCountLines(b *testing.B) {
+	data := hugeFile(b, 32<<20)
+	win := newTestEditor().NewColumn().newWindow(BytesContent(data))
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		win.tag.setStatus("")
+		win.countLines()
+		runEvents(b, func() bool {
+			return strings.HasSuffix(win.tag.String(), " lines ")
+		})
+		win.bg.Wait()
+	}
+}
@@ -13,7 +13,8 @@ type Column struct {
 	firstWin *Window
 	col      ui.Column
 
-	x float64
+	x          float64
+	lineHeight float64 // set by SetLineHeight
 
 	next *Column
 }
@@ -22,6 +23,14 @@ func (col *Column) NewWindow() *Window {
 	return col.newWindow(BytesContent([]byte{}))
 }
 
+// NewWindowContent creates a new window holding content
+// named name that isn't backed by any file.
+func (col *Column) NewWindowContent(name string, content []byte) *Window {
+	win := col.newWindow(BytesContent(content))
+	win.SetFilename(name)
+	return win
+}
+
 func (col *Column) NewWindowFile(filename string) (*Window, error) {
 	f, err := os.Open(filename)
 	if err != nil {
@@ -36,18 +45,50 @@ func (col *Column) NewWindowFile(filename string) (*Window, error) {
 	if err != nil {
 		return nil, err
 	}
-	win := col.newWindow(mm)
+	var con Content = mm
+	enc := defaultEncoding
+	large := len(mm.Bytes()) >= largeFileSize
+	if !large {
+		enc = DetectEncoding(mm.Bytes())
+	}
+	if enc != defaultEncoding {
+		text, err := enc.Decode(mm.Bytes())
+		mm.Close()
+		if err != nil {
+			return nil, err
+		}
+		con = BytesContent(text)
+	}
+	win := col.newWindow(con)
+	win.enc, win.savedEnc = enc, enc
 	win.SetFilename(filename)
+	if large {
+		// Large files aren't journaled as their content would
+		// have to be copied to the swap directory.
+		win.buf.startJournal = nil
+	}
 	q := win.tag.buf.End()
 	win.tag.q0, win.tag.q1 = q, q
+	if large {
+		win.countLines()
+	}
 	return win, nil
 }
 
 func (col *Column) newWindow(con Content) *Window {
 	buf := NewUndoBuffer(undo.NewBuffer(con.Bytes()))
-	win := &Window{con: con, buf: buf}
+	win := &Window{
+		con:      con,
+		buf:      buf,
+		enc:      defaultEncoding,
+		savedEnc: defaultEncoding,
+		stop:     make(chan struct{}),
+	}
+	col.ed.lastID++
+	win.id = col.ed.lastID
 	win.tag = newText(win, &BasicBuffer{[]rune("\x00Del Put Undo Redo ")})
 	win.body = newText(win, buf)
+	buf.startJournal = win.startJournal
 	col.appendWindow(win)
 
 	window := col.col.NewWindow(win)
@@ -124,13 +165,6 @@ func (col *Column) MoveWindow(win *Window, y float64) {
 		return
 	}
 
-	if y == target.y {
-		// TODO: If this happens, adjust position of the windows
-		// to ensure at least one line of each window is shown.
-		// Forbid it for now as it would cause panic otherwise.
-		return
-	}
-
 	if win == target || win == target.next {
 		if win == col.firstWin {
 			return
@@ -143,6 +177,9 @@ func (col *Column) MoveWindow(win *Window, y float64) {
 		target.next = win
 	}
 	win.SetY(y)
+	// Dropping the window at the top of another one, or close
+	// to it, would leave it without space.
+	col.fit()
 }
 
 // TODO: Temporary hack.
@@ -154,12 +191,19 @@ func (col *Column) maybe_Move_To_Different_Column(win *Window) {
 	}
 }
 
+// windows returns the windows of the column.
+func (col *Column) windows() []*Window {
+	var wins []*Window
+	for win := col.firstWin; win != nil; win = win.next {
+		wins = append(wins, win)
+	}
+	return wins
+}
+
 func (col *Column) Close() error {
-	win := col.firstWin
-	for win != nil {
+	for col.firstWin != nil {
 		// TODO: Check errors.
-		win.Close()
-		win = win.next
+		col.firstWin.Close()
 	}
 	col.col.Update(ui.Delete)
 	col.ed.removeColumn(col)
@@ -1,13 +1,12 @@
 package core
 
 import (
-	"errors"
 	"fmt"
 	"io"
-	"os/exec"
+	"os"
+	"strconv"
 	"strings"
-
-	"github.com/mibk/syd/ui"
+	"unicode"
 )
 
 type cmdContext interface {
@@ -17,212 +16,209 @@ type cmdContext interface {
 }
 
 func execute(ctx cmdContext, command string) {
+	command = strings.TrimSpace(command)
 	if command == "" {
 		return
 	}
+	name, arg := command, ""
+	if i := strings.IndexFunc(command, unicode.IsSpace); i != -1 {
+		name, arg = command[:i], strings.TrimSpace(command[i:])
+	}
+
+	// Del!, Delcol!, Exit! and Load! discard unsaved changes
+	// without asking.
+	force := false
+	switch name {
+	case "Del!", "Delcol!", "Exit!", "Load!":
+		name, force = strings.TrimSuffix(name, "!"), true
+	}
+
 	// TODO: Print err if the context isn't sufficient.
-	switch command {
+	switch name {
 	case "Exit":
-		// TODO: This is just a temporary solution
-		// until a proper solution is found.
-		go func() {
-			ui.Events <- ui.Quit
-		}()
+		ed := ctx.editor()
+		if ed.confirm(name, ed, force, ed.windows()) {
+			ed.Quit()
+		}
+
+	case "Scope":
+		ed := ctx.editor()
+		if arg == "" {
+			errorf(ctx, "Scope: %s\n", ed.scope)
+			return
+		}
+		ed.scope = arg
+
+	case "Search":
+		searchDir(ctx, arg)
+
+	case "Replace":
+		replace(ctx, arg)
+
+	case "Kill":
+		ctx.editor().kill(strings.Fields(arg))
+
+	case "Win", "Term":
+		ed := ctx.editor()
+		col := ed.recentCol()
+		if c, ok := ctx.column(); ok {
+			col = c
+		}
+		start := col.startWin
+		if name == "Term" {
+			start = col.startTerm
+		}
+		if _, err := start(ctxDir(ctx), arg, ctxEnv(ctx)); err != nil {
+			errorf(ctx, "%s: %v\n", name, err)
+		}
 
 	case "Newcol":
 		ctx.editor().NewColumn()
 
+	case "Recover":
+		ctx.editor().recover(arg)
+
+	case "Dump", "Load":
+		ed := ctx.editor()
+		if arg == "" {
+			arg = DefaultDumpFile()
+		}
+		var err error
+		if name == "Dump" {
+			err = ed.DumpFile(arg)
+		} else if ed.confirm(name, ed, force, ed.windows()) {
+			err = ed.LoadFile(arg)
+		}
+		if err != nil {
+			errorf(ed, "%s: %v\n", name, err)
+		}
+
 	case "Delcol", "New":
 		col, ok := ctx.column()
 		if !ok {
 			return
 		}
-		switch command {
+		switch name {
 		case "Delcol":
-			col.Close()
+			if ctx.editor().confirm(name, col, force, col.windows()) {
+				col.Close()
+			}
 		case "New":
 			col.NewWindow()
 		}
 
-	case "Del", "Put", "Undo", "Redo":
+	case "Del", "Put", "Undo", "Redo", "Encoding", "Hex", "Intr",
+		"Addsel", "Splitsel", "Onesel":
 		win, ok := ctx.window()
 		if !ok {
 			return
 		}
-		switch command {
+		switch name {
 		case "Del":
-			win.Close()
+			if ctx.editor().confirm(name, win, force, []*Window{win}) {
+				win.Close()
+			}
 		case "Put":
-			win.saveFile()
-			win.buf.Clean()
+			if win.readOnly {
+				errorf(win, "Put: %s is read-only\n", win.filename)
+				return
+			}
+			if err := win.saveFile(); err != nil {
+				errorf(win, "Put: %v\n", err)
+			}
+		case "Encoding":
+			if arg == "" {
+				errorf(win, "%s: %v\n", win.filename, win.enc)
+				return
+			}
+			enc, err := ParseEncoding(arg)
+			if err != nil {
+				errorf(win, "Encoding: %v\n", err)
+				return
+			}
+			win.SetEncoding(enc)
+		case "Hex":
+			win.hex = !win.hex
+		case "Intr":
+			if win.shell != nil {
+				win.shell.interrupt()
+			}
+		case "Addsel":
+			if err := win.body.addSelection(); err != nil {
+				errorf(win, "Addsel: %v\n", err)
+			}
+		case "Splitsel":
+			if err := win.body.splitSelections(arg); err != nil {
+				errorf(win, "Splitsel: %v\n", err)
+			}
+		case "Onesel":
+			win.body.extra = nil
 		case "Undo":
-			win.body.Select(win.buf.Undo())
+			if !win.readOnly {
+				win.body.Select(win.buf.Undo())
+			}
 		case "Redo":
-			win.body.Select(win.buf.Redo())
+			if !win.readOnly {
+				win.body.Select(win.buf.Redo())
+			}
 		}
 	default:
 		shellexec(ctx, command)
 	}
 }
 
-type writeFlusher interface {
-	io.Writer
-	flush()
-}
-
-func shellexec(ctx cmdContext, command string) {
-	ed := ctx.editor()
-
-	var stdin io.Reader
-	stderr := ed.stderr()
-	defer stderr.flush()
-	stdout := stderr
-
-	pipeln, err := parse(command)
-	if err != nil {
-		if err != errEmptyCmd {
-			fmt.Fprintf(stderr, "syntax error: %v\n", err)
-		}
-		return
-	}
-
-	if pipeln.pipeInput || pipeln.pipeOutput {
-		win, ok := ctx.window()
-		if !ok {
-			fmt.Fprintln(stderr, "no current window")
-			return
-		}
-		if pipeln.pipeInput {
-			// TODO: Implement this using io.Reader; read directly
-			// from the buffer.
-			q0, q1 := win.body.Selected()
-			selected := win.body.SelectionToString(q0, q1)
-			stdin = strings.NewReader(selected)
-		}
-		if pipeln.pipeOutput {
-			stdout = win
-			defer stdout.flush()
-		}
-	}
-
-	if err := pipeln.Exec(stdin, stdout, stderr); err != nil {
-		fmt.Fprintln(stderr, err)
-		return
-	}
-}
-
-var errEmptyCmd = errors.New("empty command")
-
-func parse(s string) (*pipeline, error) {
-	s = strings.TrimSpace(s)
-	if s == "" {
-		return nil, errEmptyCmd
-	}
-	p := &pipeline{}
-	switch r := s[0]; r {
-	case '<', '|', '>':
-		s = s[1:]
-		p.pipeInput = true
-		p.pipeOutput = true
-		if r == '<' {
-			p.pipeInput = false
-		} else if r == '>' {
-			p.pipeOutput = false
-		}
-	}
-
-	var err error
-	p.pipe, err = parsePipe(s)
-	return p, err
-}
-
-func parsePipe(s string) (*pipe, error) {
-	p := &pipe{}
-
-	var err error
-	i := strings.LastIndexByte(s, '|')
-	if i == -1 {
-		p.cmd, err = parseCmd(s)
-		return p, err
-	}
-
-	p.cmd, err = parseCmd(s[i+1:])
-	if err != nil {
-		return nil, err
-	}
-	p.prev, err = parsePipe(s[:i])
-	if err != nil {
-		return nil, err
-	}
-	return p, nil
+// errorf formats according to a format specifier and writes
+// the message to the +Errors window of the directory of ctx.
+func errorf(ctx cmdContext, format string, a ...interface{}) {
+	w := ctx.editor().stderr(ctxDir(ctx))
+	fmt.Fprintf(w, format, a...)
+	w.flush()
 }
 
-func parseCmd(s string) (*command, error) {
-	args := strings.Fields(s)
-	if len(args) == 0 {
-		return nil, errors.New("missing command")
+// ctxEnv returns the environment variables describing the window
+// of ctx to the commands executed in it:
+//
+//	$samfile, $%	the name of the file
+//	$winid		the id of the window
+//	$sydaddr	the selection in the body as #q0,#q1
+func ctxEnv(ctx cmdContext) []string {
+	win, ok := ctx.window()
+	if !ok {
+		return nil
+	}
+	q0, q1 := win.body.Selected()
+	return []string{
+		"samfile=" + win.filename,
+		"%=" + win.filename,
+		"winid=" + strconv.Itoa(win.id),
+		fmt.Sprintf("sydaddr=#%d,#%d", q0, q1),
 	}
-	return &command{cmd: args[0], args: args[1:]}, nil
 }
 
-type pipeline struct {
-	pipeInput  bool
-	pipeOutput bool
-	*pipe
-}
-
-type pipe struct {
-	cmd  *command
-	prev *pipe
-}
-
-func (p *pipe) Exec(stdin io.Reader, stdout, stderr io.Writer) error {
-	wc, err := p.cmd.Start(stdout, stderr)
-	if err != nil {
-		return err
-	}
-
-	if p.prev != nil {
-		if err := p.prev.Exec(stdin, wc, stderr); err != nil {
-			return err
-		}
-	} else if stdin != nil {
-		if _, err := io.Copy(wc, stdin); err != nil {
-			return err
+// envLookup returns a function looking up variables in env,
+// and in the environment of syd if not found.
+func envLookup(env []string) func(string) string {
+	return func(name string) string {
+		for i := len(env) - 1; i >= 0; i-- {
+			if strings.HasPrefix(env[i], name+"=") {
+				return env[i][len(name)+1:]
+			}
 		}
+		return os.Getenv(name)
 	}
-	return wc.Close()
-}
-
-type command struct {
-	cmd  string
-	args []string
 }
 
-func (c *command) Start(stdout, stderr io.Writer) (io.WriteCloser, error) {
-	cmd := exec.Command(c.cmd, c.args...)
-	cmd.Stdout = stdout
-	cmd.Stderr = stderr
-
-	wc, err := cmd.StdinPipe()
-	if err != nil {
-		return nil, err
-	}
-
-	if err := cmd.Start(); err != nil {
-		return nil, err
+// ctxDir returns the directory commands executed in ctx are run
+// in: the directory of the window's file, or the current directory.
+func ctxDir(ctx cmdContext) string {
+	if win, ok := ctx.window(); ok {
+		return win.dir()
 	}
-	return &procCloser{wc, cmd}, nil
-}
-
-type procCloser struct {
-	io.WriteCloser
-	cmd *exec.Cmd
+	wd, _ := os.Getwd()
+	return wd
 }
 
-func (pc *procCloser) Close() error {
-	if err := pc.WriteCloser.Close(); err != nil {
-		return err
-	}
-	return pc.cmd.Wait()
+type writeFlusher interface {
+	io.Writer
+	flush()
 }
@@ -2,8 +2,15 @@ package core
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/mibk/syd/ui"
 )
 
 func TestShellParsing(t *testing.T) {
@@ -31,10 +38,57 @@ func TestShellParsing(t *testing.T) {
 			"ls |grep -v a",
 			`"ls" [] | "grep" ["-v" "a"]`,
 		},
+		{
+			`grep "a b" 'c  d' e\ f`,
+			`"grep" ["a b" "c  d" "e f"]`,
+		},
+		{
+			`|sed 's/|/-/' | tr "|" \|`,
+			`| "sed" ["s/|/-/"] | "tr" ["|" "|"]`,
+		},
+		{
+			`echo 'it''s' "say \"hi\" \\ \n" a"b"'c'`,
+			`"echo" ["its" "say \"hi\" \\ \\n" "abc"]`,
+		},
+		{
+			`echo $samfile ${winid}x "$% $none" $none '$samfile' \$x $ 1$`,
+			`"echo" ["/src/main.go" "7x" "/src/main.go " "$samfile" "$x" "$" "1$"]`,
+		},
+		{
+			`ls ~ ~/src a~ "~"`,
+			`"ls" ["/home/gopher" "/home/gopher/src" "a~" "~"]`,
+		},
+		{
+			"make && ./run",
+			`"/bin/sh" ["-c" "make && ./run"]`,
+		},
+		{
+			">sort -u >out.txt",
+			`> "/bin/sh" ["-c" "sort -u >out.txt"]`,
+		},
+		{
+			"|fmt || cat",
+			`| "/bin/sh" ["-c" "fmt || cat"]`,
+		},
+		{
+			"go test; echo $?",
+			`"/bin/sh" ["-c" "go test; echo $?"]`,
+		},
+		{
+			"echo $(date)",
+			`"/bin/sh" ["-c" "echo $(date)"]`,
+		},
 	}
 
+	t.Setenv("SHELL", "/bin/sh")
+	env := envLookup([]string{
+		"samfile=/src/main.go",
+		"%=/src/main.go",
+		"winid=7",
+		"HOME=/home/gopher",
+	})
 	for _, tt := range tests {
-		p, err := parse(tt.cmd)
+		p, err := parse(tt.cmd, env)
 		if err != nil {
 			t.Errorf("%s: unexpected syntax error: %v", tt.cmd, err)
 			continue
@@ -52,10 +106,15 @@ func TestShellSyntaxErrors(t *testing.T) {
 	}{
 		{"    ", errEmptyCmd.Error()},
 		{" cat | | cat", "missing command"},
+		{"sort |", "missing command"},
+		{`echo "abc`, "unterminated quoted string"},
+		{`echo 'abc`, "unterminated quoted string"},
+		{`echo abc\`, "trailing backslash"},
+		{`echo ${abc`, "missing }"},
 	}
 
 	for _, tt := range tests {
-		_, err := parse(tt.cmd)
+		_, err := parse(tt.cmd, os.Getenv)
 		if err == nil {
 			t.Errorf("%q: should have syntax error", tt.cmd)
 			continue
@@ -66,6 +125,34 @@ func TestShellSyntaxErrors(t *testing.T) {
 	}
 }
 
+func TestShellGlob(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.go", "b.go", "c.txt", "*.go"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		cmd  string
+		want []string
+	}{
+		{"ls *.go", []string{"*.go", "a.go", "b.go"}},
+		{`ls "*.go" '*'.go \*.go`, []string{"*.go", "*.go", "*.go"}},
+		{"ls ?.txt *.c", []string{"c.txt", "*.c"}},
+		{"ls " + dir + "/[ab].go", []string{dir + "/a.go", dir + "/b.go"}},
+	}
+	for _, tt := range tests {
+		p, err := parse(tt.cmd, os.Getenv)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.cmd, err)
+			continue
+		}
+		if got := p.cmd.argv(dir); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.cmd, got, tt.want)
+		}
+	}
+}
+
 func (p *pipeline) String() string {
 	sign := ""
 	if p.pipeInput {
@@ -90,3 +177,154 @@ func (p *pipe) String() string {
 func (c *command) String() string {
 	return fmt.Sprintf("%q %q", c.cmd, c.args)
 }
+
+func TestDirtyProtection(t *testing.T) {
+	t.Setenv("SYDSWAP", t.TempDir())
+	dir := t.TempDir()
+	ed := newTestEditor()
+	col := ed.recentCol()
+	open := func(name string, dirty bool) *Window {
+		win, err := col.NewWindowFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dirty {
+			win.body.Insert("unsaved")
+		}
+		return win
+	}
+	clean := open("clean.txt", false)
+	a := open("a.txt", true)
+	b := open("b.txt", true)
+	scratch := col.NewWindow()
+	scratch.body.Insert("scratch")
+
+	closed := func(win *Window) bool {
+		for _, w := range col.windows() {
+			if w == win {
+				return false
+			}
+		}
+		return true
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	errors := func(dir string) string {
+		win := ed.errorWindow(dir)
+		s := win.body.String()
+		win.body.Select(0, win.buf.End())
+		win.body.DeleteSel()
+		return s
+	}
+
+	execute(clean, "Del")
+	execute(scratch, "Del")
+	if !closed(clean) || !closed(scratch) {
+		t.Fatal("clean windows must be closed without asking")
+	}
+
+	execute(a, "Del")
+	if closed(a) {
+		t.Fatal("dirty window closed by the first Del")
+	}
+	if got, want := errors(dir), "Del: "+a.filename+" modified\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	execute(b, "Del")
+	execute(a, "Del")
+	if closed(a) {
+		t.Fatal("Del of a different window must not confirm")
+	}
+	execute(a, "Del")
+	if !closed(a) {
+		t.Fatal("repeated Del didn't close the window")
+	}
+	errors(dir)
+
+	execute(b, "Del!")
+	if !closed(b) {
+		t.Fatal("Del! didn't close the window")
+	}
+	if got := errors(dir); got != "" {
+		t.Errorf("Del! reported %q", got)
+	}
+
+	c := open("c.txt", true)
+	col2 := ed.NewColumn()
+	d, err := col2.NewWindowFile(filepath.Join(dir, "d.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.body.Insert("unsaved")
+	execute(col2, "Delcol")
+	if ed.firstCol.next != col2 {
+		t.Fatal("column with a dirty window deleted by the first Delcol")
+	}
+	if got, want := errors(wd), "Delcol: "+d.filename+" modified\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	execute(col2, "Delcol")
+	if ed.firstCol.next != nil {
+		t.Fatal("repeated Delcol didn't delete the column")
+	}
+	if _, ok := ed.wins[d.filename]; ok {
+		t.Error("window of the deleted column still open")
+	}
+
+	execute(ed, "Exit")
+	select {
+	case ev := <-ui.Events:
+		t.Fatalf("got %v, want Exit to refuse", ev)
+	case <-time.After(10 * time.Millisecond):
+	}
+	if got, want := errors(wd), "Exit: "+c.filename+" modified\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	execute(ed, "Exit")
+	select {
+	case ev := <-ui.Events:
+		if ev != ui.Quit {
+			t.Errorf("got %v, want ui.Quit", ev)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("second Exit didn't quit")
+	}
+}
+
+func TestExecuteWithArg(t *testing.T) {
+	ed := newTestEditor()
+	win := ed.recentCol().NewWindow()
+	win.body.Insert("Scope\nsel")
+	win.body.ExecuteWithArg(2, "/x/,$")
+	if got, want := ed.scope, "/x/,$"; got != want {
+		t.Errorf("got scope %q, want %q", got, want)
+	}
+
+	// The selection is executed if q is inside it.
+	win.body.Select(0, 9)
+	win.body.ExecuteWithArg(7, "arg")
+	if got, want := ed.scope, "sel arg"; got != want {
+		t.Errorf("got scope %q, want %q", got, want)
+	}
+}
+
+func TestRanges(t *testing.T) {
+	ed := newTestEditor()
+	win := ed.recentCol().NewWindow()
+	win.body.Insert("Scope 1\nfoo bar\nfoo bar")
+	win.body.ExecuteRange(0, 7, "")
+	if got, want := ed.scope, "1"; got != want {
+		t.Errorf("got scope %q, want %q", got, want)
+	}
+	win.body.ExecuteRange(0, 5, "2,3")
+	if got, want := ed.scope, "2,3"; got != want {
+		t.Errorf("got scope %q, want %q", got, want)
+	}
+
+	win.body.PlumbRange(8, 15)
+	if q0, q1 := win.body.Selected(); q0 != 16 || q1 != 23 {
+		t.Errorf("got selection %d,%d, want 16,23", q0, q1)
+	}
+}
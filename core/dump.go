@@ -0,0 +1,233 @@
+package core
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The layout of the editor is dumped in a line-oriented format:
+//
+//	syd dump 1
+//	dir "/home/gopher/src"
+//	tag "Newcol Exit "
+//	column 0 "New Delcol "
+//	window 0 12 12 0 "main.go" "main.go\x00Del Put Undo Redo "
+//	body "package main\n..."
+//
+// A column line is followed by the lines of its windows. A window line
+// holds the position of the window, the selection, the origin, the
+// filename, and the tag. It is followed by the body line if the window
+// isn't backed by a file, or if it has unsaved changes.
+const dumpMagic = "syd dump 1"
+
+// DefaultDumpFile returns the file used by Dump and Load
+// if no file is specified.
+func DefaultDumpFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "syd.dump"
+	}
+	return filepath.Join(home, "syd.dump")
+}
+
+// Dump writes the layout of the editor to w.
+func (ed *Editor) Dump(w io.Writer) error {
+	dir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, dumpMagic)
+	fmt.Fprintf(bw, "dir %q\n", dir)
+	// The status in the tags is transient, so it isn't dumped.
+	fmt.Fprintf(bw, "tag %q\n", ed.tag.stringWithoutStatus())
+	for col := ed.firstCol; col != nil; col = col.next {
+		fmt.Fprintf(bw, "column %v %q\n", col.x, col.tag.stringWithoutStatus())
+		for win := col.firstWin; win != nil; win = win.next {
+			body := win.body
+			fmt.Fprintf(bw, "window %v %d %d %d %q %q\n", win.y,
+				body.q0, body.q1, body.origin, win.filename, win.tag.stringWithoutStatus())
+			if !win.backedByFile() || win.Dirty() {
+				fmt.Fprintf(bw, "body %q\n", body.String())
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+// backedByFile reports whether the content of the window can be
+// reloaded from a file.
+func (win *Window) backedByFile() bool {
+	return isFileName(win.filename)
+}
+
+// isFileName reports whether name is the name of a file rather than
+// of a special window, such as +Errors, or of a window started by Win,
+// such as -sh.
+func isFileName(name string) bool {
+	if name == "" {
+		return false
+	}
+	base := filepath.Base(name)
+	return !strings.HasPrefix(base, "+") && !strings.HasPrefix(base, "-")
+}
+
+// DumpFile writes the layout of the editor to the named file.
+func (ed *Editor) DumpFile(filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := ed.Dump(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// LoadFile loads the layout of the editor from the named file.
+func (ed *Editor) LoadFile(filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return ed.Load(f)
+}
+
+type dumpedColumn struct {
+	x    float64
+	tag  string
+	wins []*dumpedWindow
+}
+
+type dumpedWindow struct {
+	y              float64
+	q0, q1, origin int64
+	filename, tag  string
+	body           string
+	hasBody        bool
+}
+
+// Load replaces the current layout of the editor with the layout
+// read from r, which was written by Dump. The open windows are closed
+// without saving them.
+func (ed *Editor) Load(r io.Reader) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<30)
+	if !sc.Scan() || sc.Text() != dumpMagic {
+		if err := sc.Err(); err != nil {
+			return err
+		}
+		return errors.New("not a syd dump")
+	}
+
+	var dir, tag string
+	var cols []*dumpedColumn
+	for n := 2; sc.Scan(); n++ {
+		line := sc.Text()
+		var err error
+		switch kw := strings.SplitN(line, " ", 2)[0]; kw {
+		case "dir":
+			_, err = fmt.Sscanf(line, "dir %q", &dir)
+		case "tag":
+			_, err = fmt.Sscanf(line, "tag %q", &tag)
+		case "column":
+			c := new(dumpedColumn)
+			_, err = fmt.Sscanf(line, "column %g %q", &c.x, &c.tag)
+			cols = append(cols, c)
+		case "window":
+			if len(cols) == 0 {
+				return fmt.Errorf("line %d: window outside of a column", n)
+			}
+			w := new(dumpedWindow)
+			_, err = fmt.Sscanf(line, "window %g %d %d %d %q %q",
+				&w.y, &w.q0, &w.q1, &w.origin, &w.filename, &w.tag)
+			c := cols[len(cols)-1]
+			c.wins = append(c.wins, w)
+		case "body":
+			if len(cols) == 0 || len(cols[len(cols)-1].wins) == 0 {
+				return fmt.Errorf("line %d: body without a window", n)
+			}
+			c := cols[len(cols)-1]
+			w := c.wins[len(c.wins)-1]
+			_, err = fmt.Sscanf(line, "body %q", &w.body)
+			w.hasBody = true
+		default:
+			return fmt.Errorf("line %d: unknown keyword %q", n, kw)
+		}
+		if err != nil {
+			return fmt.Errorf("line %d: %v", n, err)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+
+	ed.kill(nil)
+	ed.closeColumns()
+	ed.tag.setText(tag)
+	for _, c := range cols {
+		col := ed.NewColumn()
+		col.SetX(c.x)
+		col.tag.setText(c.tag)
+		for _, w := range c.wins {
+			if err := col.loadWindow(w, dir); err != nil {
+				errorf(ed, "Load: %v\n", err)
+			}
+		}
+	}
+	return nil
+}
+
+func (col *Column) loadWindow(w *dumpedWindow, dir string) error {
+	filename := w.filename
+	var win *Window
+	if !isFileName(filename) {
+		win = col.NewWindow()
+		if filename != "" {
+			win.SetFilename(filename)
+		}
+	} else {
+		if !filepath.IsAbs(filename) {
+			if wd, err := os.Getwd(); err != nil || wd != dir {
+				filename = filepath.Join(dir, filename)
+			}
+		}
+		var err error
+		win, err = col.NewWindowFile(filename)
+		if err != nil {
+			return err
+		}
+	}
+	tag := w.tag
+	if i := strings.IndexByte(tag, 0); i != -1 {
+		tag = filename + tag[i:]
+	}
+	win.tag.setText(tag)
+
+	if w.hasBody {
+		win.body.Select(0, win.buf.End())
+		win.body.Insert(w.body)
+		win.buf.Commit()
+		if !win.backedByFile() {
+			win.buf.Clean()
+		}
+	}
+	end := win.buf.End()
+	clamp := func(q int64) int64 {
+		if q > end {
+			return end
+		}
+		return q
+	}
+	win.body.Select(clamp(w.q0), clamp(w.q1))
+	win.body.SetOrigin(clamp(w.origin))
+	win.SetY(w.y)
+	return nil
+}
@@ -0,0 +1,140 @@
+package core
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDumpLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syd-dump")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	t.Setenv("SYDSWAP", dir)
+	file := filepath.Join(dir, "hello.txt")
+	if err := ioutil.WriteFile(file, []byte("hello\nworld\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ed := newTestEditor()
+	ed.tag.setText("Newcol Exit Dump ")
+	col := ed.NewColumn()
+	win, err := col.NewWindowFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	win.body.Select(6, 11)
+	errs := col.NewWindow()
+	errs.SetFilename("+Errors")
+	errs.body.Insert("oops\n")
+	errs.SetY(0.5)
+	col2 := ed.NewColumn()
+	col2.SetX(0.6)
+	col2.tag.setText("New Delcol Kill ")
+	dirty, err := col2.NewWindowFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dirty.body.Insert("oh, ")
+	ed.tag.setStatus("1 running ")
+	win.tag.setStatus("2 lines ")
+
+	var buf bytes.Buffer
+	if err := ed.Dump(&buf); err != nil {
+		t.Fatal(err)
+	}
+	dump := buf.String()
+	if strings.Contains(dump, "running") || strings.Contains(dump, "lines") {
+		t.Errorf("status dumped:\n%s", dump)
+	}
+
+	ed2 := newTestEditor()
+	ed2.NewColumn().NewWindow()
+	if err := ed2.Load(strings.NewReader(dump)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	buf.Reset()
+	if err := ed2.Dump(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != dump {
+		t.Errorf("got:\n%s\nwant:\n%s", got, dump)
+	}
+	if _, ok := ed2.wins["+Errors"]; !ok {
+		t.Errorf("+Errors window not restored")
+	}
+	if w := ed2.firstCol.next.firstWin; !w.Dirty() {
+		t.Errorf("window with unsaved changes should be dirty")
+	}
+	if w := ed2.firstCol.firstWin; w.Dirty() {
+		t.Errorf("unmodified window shouldn't be dirty")
+	}
+	if ed2.wins[file] != ed2.firstCol.firstWin {
+		t.Errorf("file not looked up in the first window")
+	}
+	ed2.firstCol.firstWin.Close()
+	if ed2.wins[file] != ed2.firstCol.next.firstWin {
+		t.Errorf("file not looked up in the other window after closing")
+	}
+}
+
+func TestLoadDirty(t *testing.T) {
+	t.Setenv("SYDSWAP", t.TempDir())
+	dir := t.TempDir()
+	dump := filepath.Join(dir, "syd.dump")
+	ed := newTestEditor()
+	if err := ed.DumpFile(dump); err != nil {
+		t.Fatal(err)
+	}
+	win, err := ed.NewColumn().NewWindowFile(filepath.Join(dir, "a.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	win.body.Insert("unsaved")
+
+	execute(ed, "Load "+dump)
+	if ed.firstCol == nil || ed.firstCol.firstWin != win {
+		t.Fatal("Load closed a dirty window")
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := ed.errorWindow(wd).body.String(), "Load: "+win.filename+" modified\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	execute(ed, "Load! "+dump)
+	if ed.firstCol != nil {
+		t.Error("Load! didn't replace the layout")
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		dump string
+		err  string
+	}{
+		{"", "not a syd dump"},
+		{"syd dump 1\nwindow 0 0 0 0 \"\" \"\"", "line 2: window outside of a column"},
+		{"syd dump 1\ncolumn 0 \"\"\nbody \"x\"", "line 3: body without a window"},
+		{"syd dump 1\nrow 1", `line 2: unknown keyword "row"`},
+		{"syd dump 1\ntag Newcol", "line 2: expected quoted string"},
+	}
+
+	for _, tt := range tests {
+		ed := newTestEditor()
+		err := ed.Load(strings.NewReader(tt.dump))
+		if err == nil {
+			t.Errorf("%q: expected error", tt.dump)
+			continue
+		}
+		if got := err.Error(); got != tt.err {
+			t.Errorf("%q: got %q, want %q", tt.dump, got, tt.err)
+		}
+	}
+}
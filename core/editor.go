@@ -1,24 +1,46 @@
 package core
 
-import "github.com/mibk/syd/ui"
+import (
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/mibk/syd/ui"
+)
 
 type Editor struct {
 	ui ui.UI
 
 	tag *Text
 
-	// TODO: Make it just an io.Reader so it's easier to work
-	// with.
-	errWin *Window
-
 	firstCol *Column
 	wins     map[string]*Window
 	mode     int
+
+	warned     warning     // last command refused by confirm
+	procs      []*proc     // running commands
+	lastID     int         // id of the most recently created window
+	lastSearch searchQuery // query of the last incremental search
+
+	// scope is the address commands reading the body
+	// operate on if nothing is selected.
+	scope string
+
+	minWidth float64       // set by SetMinColumnWidth
+	done     chan struct{} // closed by Close
+}
+
+type warning struct {
+	cmd    string
+	target cmdContext
+	time   time.Time
 }
 
 func NewEditor() *Editor {
 	ed := &Editor{
-		wins: make(map[string]*Window),
+		wins:  make(map[string]*Window),
+		scope: ",",
+		done:  make(chan struct{}),
 	}
 	ed.tag = newText(ed, &BasicBuffer{[]rune("Newcol Exit ")})
 	return ed
@@ -28,6 +50,7 @@ func (ed *Editor) SetUI(u ui.UI) {
 	ed.ui = u
 	q := ed.tag.buf.End()
 	ed.tag.q0, ed.tag.q1 = q, q
+	go ed.autosave()
 }
 
 func (ed *Editor) Tag() *Text { return ed.tag }
@@ -64,11 +87,6 @@ func (ed *Editor) MoveColumn(col *Column, x float64) {
 		return
 	}
 
-	if x == target.x {
-		// TODO: Adjust position. See the method Column.MoveWindow.
-		return
-	}
-
 	if col == target || col == target.next {
 		if col == ed.firstCol {
 			return
@@ -79,15 +97,84 @@ func (ed *Editor) MoveColumn(col *Column, x float64) {
 		target.next = col
 	}
 	col.SetX(x)
+	ed.fitColumns()
+}
+
+// Quit makes the main loop of the UI return.
+func (ed *Editor) Quit() {
+	// TODO: This is just a temporary solution
+	// until a proper solution is found.
+	go func() {
+		ui.Events <- ui.Quit
+	}()
+}
+
+// confirmInterval is the time in which a refused command must
+// be repeated to discard unsaved changes.
+const confirmInterval = 5 * time.Second
+
+// confirm reports whether the command cmd executed on target may
+// discard the unsaved changes of wins. If any of the windows is
+// dirty, the first execution is refused and the modified files are
+// reported; repeating the command within confirmInterval, or
+// forcing it, discards the changes.
+func (ed *Editor) confirm(cmd string, target cmdContext, force bool, wins []*Window) bool {
+	if force {
+		return true
+	}
+	var dirty []*Window
+	for _, win := range wins {
+		if win.backedByFile() && win.Dirty() {
+			dirty = append(dirty, win)
+		}
+	}
+	if len(dirty) == 0 {
+		return true
+	}
+	w := &ed.warned
+	if w.cmd == cmd && w.target == target && time.Since(w.time) < confirmInterval {
+		*w = warning{}
+		return true
+	}
+	sort.Slice(dirty, func(i, j int) bool {
+		return dirty[i].filename < dirty[j].filename
+	})
+	for _, win := range dirty {
+		errorf(target, "%s: %s modified\n", cmd, win.filename)
+	}
+	*w = warning{cmd: cmd, target: target, time: time.Now()}
+	return false
 }
 
+// windows returns all windows of the editor.
+func (ed *Editor) windows() []*Window {
+	var wins []*Window
+	for col := ed.firstCol; col != nil; col = col.next {
+		wins = append(wins, col.windows()...)
+	}
+	return wins
+}
+
+// Close closes all windows and kills the running commands,
+// so that they don't outlive the editor.
 func (ed *Editor) Close() error {
+	select {
+	case <-ed.done:
+	default:
+		close(ed.done)
+	}
+	ed.kill(nil)
+	ed.closeColumns()
+	return nil
+}
+
+// closeColumns closes all columns with their windows.
+func (ed *Editor) closeColumns() {
 	col := ed.firstCol
 	for col != nil {
 		col.Close()
 		col = col.next
 	}
-	return nil
 }
 
 func (ed *Editor) recentCol() *Column {
@@ -118,32 +205,48 @@ func (ed *Editor) editor() *Editor         { return ed }
 func (ed *Editor) column() (*Column, bool) { return nil, false }
 func (ed *Editor) window() (*Window, bool) { return nil, false }
 
-func (ed *Editor) stderr() writeFlusher {
-	return &outputWriter{ed: ed}
+// Errorf formats according to a format specifier and writes
+// the message to the +Errors window.
+func (ed *Editor) Errorf(format string, a ...interface{}) {
+	errorf(ed, format, a...)
+}
+
+// errorWindow returns the +Errors window of the directory dir,
+// creating it if needed.
+func (ed *Editor) errorWindow(dir string) *Window {
+	name := filepath.Join(dir, "+Errors")
+	if win, ok := ed.wins[name]; ok {
+		return win
+	}
+	win := ed.recentCol().NewWindow()
+	win.SetFilename(name)
+	return win
+}
+
+// stderr returns a writer appending to the +Errors window
+// of the directory dir.
+func (ed *Editor) stderr(dir string) writeFlusher {
+	return &outputWriter{ed: ed, dir: dir}
 }
 
 type outputWriter struct {
-	ed    *Editor
-	ready bool
+	ed  *Editor
+	dir string
+	win *Window // nil until the first write
 }
 
 func (w *outputWriter) Write(b []byte) (n int, err error) {
-	ed := w.ed
-	if !w.ready {
-		w.ready = true
-		if ed.errWin == nil {
-			ed.errWin = ed.recentCol().NewWindow()
-			ed.errWin.SetFilename("+Errors")
-		}
-		q := ed.errWin.body.buf.End()
-		ed.errWin.body.q0, ed.errWin.body.q1 = q, q
+	if w.win == nil {
+		w.win = w.ed.errorWindow(w.dir)
+		q := w.win.body.buf.End()
+		w.win.body.Select(q, q)
 	}
-	return ed.errWin.Write(b)
+	return w.win.Write(b)
 }
 
 func (w *outputWriter) flush() {
-	if w.ready {
-		w.ed.errWin.flush()
-		w.ready = false
+	if w.win != nil {
+		w.win.flush()
+		w.win = nil
 	}
 }
@@ -0,0 +1,19 @@
+package core
+
+import "github.com/mibk/syd/ui"
+
+// testUI is a ui.UI that doesn't display anything.
+type testUI struct{}
+
+func (testUI) NewColumn(ui.Model) ui.Column { return testColumn{} }
+
+type testColumn struct{}
+
+func (testColumn) Update(ui.Message)             {}
+func (testColumn) NewWindow(ui.Model) ui.Updater { return testColumn{} }
+
+func newTestEditor() *Editor {
+	ed := NewEditor()
+	ed.ui = testUI{}
+	return ed
+}
@@ -0,0 +1,229 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Charsets known to Encoding.
+const (
+	UTF8    = "utf-8"
+	UTF16LE = "utf-16le"
+	UTF16BE = "utf-16be"
+	Latin1  = "latin-1"
+)
+
+// An Encoding describes how the text of a file is stored on disk.
+// Windows always hold the text as UTF-8 with LF line endings; the
+// Encoding is used to convert the text back when it is saved.
+type Encoding struct {
+	Charset string
+	BOM     bool // whether the file starts with a byte order mark
+	CRLF    bool // whether lines end with CR LF
+}
+
+var defaultEncoding = Encoding{Charset: UTF8}
+
+var (
+	bomUTF8    = []byte{0xEF, 0xBB, 0xBF}
+	bomUTF16LE = []byte{0xFF, 0xFE}
+	bomUTF16BE = []byte{0xFE, 0xFF}
+)
+
+// DetectEncoding guesses the encoding of b. Only an encoding that
+// converts b back to the very same bytes is ever reported, so if
+// nothing else fits, plain UTF-8 is returned.
+func DetectEncoding(b []byte) Encoding {
+	enc := defaultEncoding
+	switch {
+	case bytes.HasPrefix(b, bomUTF8):
+		enc.BOM = true
+	case bytes.HasPrefix(b, bomUTF16LE):
+		enc.Charset, enc.BOM = UTF16LE, true
+	case bytes.HasPrefix(b, bomUTF16BE):
+		enc.Charset, enc.BOM = UTF16BE, true
+	case looksLikeUTF16(b, 1):
+		enc.Charset = UTF16LE
+	case looksLikeUTF16(b, 0):
+		enc.Charset = UTF16BE
+	case !utf8.Valid(b) && looksLikeLatin1(b):
+		enc.Charset = Latin1
+	}
+
+	text, err := enc.Decode(b)
+	if err != nil {
+		return defaultEncoding
+	}
+	enc.CRLF = hasOnlyCRLF(text)
+	if enc.CRLF {
+		text = bytes.Replace(text, []byte("\r\n"), []byte("\n"), -1)
+	}
+	if out, err := enc.Encode(text); err != nil || !bytes.Equal(out, b) {
+		return defaultEncoding
+	}
+	return enc
+}
+
+// looksLikeUTF16 reports whether b is likely UTF-16 text without
+// a BOM, i.e. whether most bytes at odd (zero = 1) or even (zero = 0)
+// positions are zero, as is common for mostly ASCII text.
+func looksLikeUTF16(b []byte, zero int) bool {
+	if len(b) < 2 || len(b)%2 != 0 {
+		return false
+	}
+	if len(b) > 512 {
+		b = b[:512]
+	}
+	n := 0
+	for i := zero; i < len(b); i += 2 {
+		if b[i] == 0 && b[i^1] != 0 {
+			n++
+		}
+	}
+	return n > len(b)/2*3/4
+}
+
+// looksLikeLatin1 reports whether b contains no control characters
+// except for the usual whitespace. Binary files are thus left alone.
+func looksLikeLatin1(b []byte) bool {
+	for _, c := range b {
+		switch {
+		case c == '\t', c == '\n', c == '\r', c == '\f':
+		case c < 0x20, c >= 0x7F && c < 0xA0:
+			return false
+		}
+	}
+	return true
+}
+
+func hasOnlyCRLF(b []byte) bool {
+	n := bytes.Count(b, []byte("\n"))
+	return n > 0 && n == bytes.Count(b, []byte("\r\n"))
+}
+
+// Decode converts b from the encoding e to UTF-8 with LF line
+// endings.
+func (e Encoding) Decode(b []byte) ([]byte, error) {
+	var text []byte
+	switch e.Charset {
+	case UTF8:
+		text = bytes.TrimPrefix(b, bomUTF8)
+	case UTF16LE, UTF16BE:
+		order := e.byteOrder()
+		if e.BOM && len(b) >= 2 && order.Uint16(b) == 0xFEFF {
+			b = b[2:]
+		}
+		if len(b)%2 != 0 {
+			return nil, errors.New("odd number of bytes in UTF-16 text")
+		}
+		u := make([]uint16, len(b)/2)
+		for i := range u {
+			u[i] = order.Uint16(b[2*i:])
+		}
+		text = []byte(string(utf16.Decode(u)))
+	case Latin1:
+		text = make([]byte, 0, len(b))
+		for _, c := range b {
+			text = append(text, string(rune(c))...)
+		}
+	default:
+		return nil, fmt.Errorf("unknown charset %q", e.Charset)
+	}
+	if e.CRLF {
+		text = bytes.Replace(text, []byte("\r\n"), []byte("\n"), -1)
+	}
+	return text, nil
+}
+
+// Encode converts the UTF-8 text with LF line endings to the encoding e.
+func (e Encoding) Encode(text []byte) ([]byte, error) {
+	if e.CRLF {
+		text = bytes.Replace(text, []byte("\n"), []byte("\r\n"), -1)
+	}
+	var b []byte
+	switch e.Charset {
+	case UTF8:
+		if e.BOM {
+			b = append(b, bomUTF8...)
+		}
+		b = append(b, text...)
+	case UTF16LE, UTF16BE:
+		order := e.byteOrder()
+		u := utf16.Encode([]rune(string(text)))
+		if e.BOM {
+			u = append([]uint16{0xFEFF}, u...)
+		}
+		b = make([]byte, 2*len(u))
+		for i, c := range u {
+			order.PutUint16(b[2*i:], c)
+		}
+	case Latin1:
+		b = make([]byte, 0, len(text))
+		for _, r := range string(text) {
+			if r > 0xFF {
+				return nil, fmt.Errorf("cannot encode %q in %s", r, Latin1)
+			}
+			b = append(b, byte(r))
+		}
+	default:
+		return nil, fmt.Errorf("unknown charset %q", e.Charset)
+	}
+	return b, nil
+}
+
+func (e Encoding) byteOrder() binary.ByteOrder {
+	if e.Charset == UTF16BE {
+		return binary.BigEndian
+	}
+	return binary.LittleEndian
+}
+
+// String returns the encoding in the form accepted by ParseEncoding,
+// e.g. "utf-16le+bom+crlf".
+func (e Encoding) String() string {
+	s := e.Charset
+	if e.BOM {
+		s += "+bom"
+	}
+	if e.CRLF {
+		s += "+crlf"
+	}
+	return s
+}
+
+// ParseEncoding parses an encoding in the form produced by
+// Encoding.String.
+func ParseEncoding(s string) (Encoding, error) {
+	fields := strings.Split(strings.ToLower(s), "+")
+	enc := Encoding{Charset: fields[0]}
+	switch enc.Charset {
+	case "utf8":
+		enc.Charset = UTF8
+	case "latin1", "iso-8859-1":
+		enc.Charset = Latin1
+	case UTF8, UTF16LE, UTF16BE, Latin1:
+	default:
+		return Encoding{}, fmt.Errorf("unknown charset %q", fields[0])
+	}
+	for _, f := range fields[1:] {
+		switch f {
+		case "bom":
+			if enc.Charset == Latin1 {
+				return Encoding{}, fmt.Errorf("%s has no byte order mark", Latin1)
+			}
+			enc.BOM = true
+		case "crlf":
+			enc.CRLF = true
+		case "lf":
+			enc.CRLF = false
+		default:
+			return Encoding{}, fmt.Errorf("unknown encoding option %q", f)
+		}
+	}
+	return enc, nil
+}
@@ -0,0 +1,91 @@
+package core
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncodingRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		file []byte
+		enc  string
+		text string
+	}{
+		{"ascii", []byte("hello\nworld\n"), "utf-8", "hello\nworld\n"},
+		{"utf-8", []byte("žluťoučký kůň\n"), "utf-8", "žluťoučký kůň\n"},
+		{"utf-8 bom", []byte("\xEF\xBB\xBFbom\n"), "utf-8+bom", "bom\n"},
+		{"crlf", []byte("a\r\nb\r\n"), "utf-8+crlf", "a\nb\n"},
+		{"mixed line endings", []byte("a\r\nb\n"), "utf-8", "a\r\nb\n"},
+		{"utf-16le bom", []byte("\xFF\xFEa\x00\n\x00"), "utf-16le+bom", "a\n"},
+		{"utf-16be bom crlf", []byte("\xFE\xFF\x00a\x00\r\x00\n"), "utf-16be+bom+crlf", "a\n"},
+		{"utf-16le", []byte("h\x00i\x00!\x00\n\x00"), "utf-16le", "hi!\n"},
+		{"latin-1", []byte("caf\xE9\n"), "latin-1", "café\n"},
+		{"binary", []byte("\x7FELF\x02\x01\x00\xFF"), "utf-8", "\x7FELF\x02\x01\x00\xFF"},
+		{"odd utf-16", []byte("\xFF\xFEa\x00b"), "utf-8", "\xFF\xFEa\x00b"},
+	}
+
+	for _, tt := range tests {
+		enc := DetectEncoding(tt.file)
+		if got := enc.String(); got != tt.enc {
+			t.Errorf("%s: got encoding %s, want %s", tt.name, got, tt.enc)
+			continue
+		}
+		text, err := enc.Decode(tt.file)
+		if err != nil {
+			t.Errorf("%s: unexpected decoding error: %v", tt.name, err)
+			continue
+		}
+		if string(text) != tt.text {
+			t.Errorf("%s: got text %q, want %q", tt.name, text, tt.text)
+		}
+		out, err := enc.Encode(text)
+		if err != nil {
+			t.Errorf("%s: unexpected encoding error: %v", tt.name, err)
+			continue
+		}
+		if !bytes.Equal(out, tt.file) {
+			t.Errorf("%s: got %q, want %q", tt.name, out, tt.file)
+		}
+	}
+}
+
+func TestParseEncoding(t *testing.T) {
+	tests := []struct {
+		s    string
+		want string
+		err  string
+	}{
+		{"UTF8", "utf-8", ""},
+		{"utf-16le+bom+crlf", "utf-16le+bom+crlf", ""},
+		{"latin1+crlf", "latin-1+crlf", ""},
+		{"utf-8+crlf+lf", "utf-8", ""},
+		{"latin-1+bom", "", "latin-1 has no byte order mark"},
+		{"ebcdic", "", `unknown charset "ebcdic"`},
+		{"utf-8+dos", "", `unknown encoding option "dos"`},
+	}
+
+	for _, tt := range tests {
+		enc, err := ParseEncoding(tt.s)
+		if err != nil {
+			if got := err.Error(); got != tt.err {
+				t.Errorf("%s: got error %q, want %q", tt.s, got, tt.err)
+			}
+			continue
+		}
+		if tt.err != "" {
+			t.Errorf("%s: expected error %q", tt.s, tt.err)
+			continue
+		}
+		if got := enc.String(); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestEncodeUnrepresentable(t *testing.T) {
+	enc := Encoding{Charset: Latin1}
+	if _, err := enc.Encode([]byte("€")); err == nil {
+		t.Errorf("encoding € in %s should fail", Latin1)
+	}
+}
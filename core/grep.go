@@ -0,0 +1,271 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/mibk/syd/gitignore"
+	"github.com/mibk/syd/ui"
+)
+
+// searchDir runs the Search command: it searches the files in the
+// tree rooted at dir for the regular expression re and lists the
+// matching lines in the +Search window of dir.
+func searchDir(ctx cmdContext, arg string) {
+	ed := ctx.editor()
+	re, dir, err := splitPattern(arg)
+	if err != nil {
+		errorf(ctx, "Search: %v\n", err)
+		return
+	}
+	rx, err := regexp.Compile(re)
+	if err != nil {
+		errorf(ctx, "Search: %v\n", err)
+		return
+	}
+	if dir == "" {
+		dir = ctxDir(ctx)
+	} else if !filepath.IsAbs(dir) {
+		dir = filepath.Join(ctxDir(ctx), dir)
+	}
+	if fi, err := os.Stat(dir); err != nil {
+		errorf(ctx, "Search: %v\n", err)
+		return
+	} else if !fi.IsDir() {
+		errorf(ctx, "Search: %s is not a directory\n", dir)
+		return
+	}
+
+	name := filepath.Join(dir, "+Search")
+	win, ok := ed.wins[name]
+	if !ok {
+		win = ed.recentCol().NewWindow()
+		win.SetFilename(name)
+	}
+	// The new results replace those of a search still running.
+	for _, p := range ed.procs {
+		if p.output == win {
+			p.kill()
+		}
+	}
+	out := win.outputStream(0, win.buf.End(), false)
+	stop := make(chan struct{})
+	p := &proc{name: "Search", output: win, cancel: func() { close(stop) }}
+	// The output still on its way when the search is killed
+	// is dropped.
+	write, done := out.write, out.done
+	out.write = func(s string) {
+		if !p.killed {
+			write(s)
+		}
+	}
+	out.done = func() {
+		if !p.killed {
+			done()
+		}
+	}
+	win.OnClose(func(bool) { p.kill() })
+	ed.addProc(p)
+	go func() {
+		err := searchFiles(dir, rx, out, stop)
+		out.Close()
+		select {
+		case ui.Events <- func() {
+			ed.removeProc(p)
+			if err != nil {
+				errorf(ctx, "Search: %v\n", err)
+			}
+		}:
+		case <-ed.done:
+		}
+	}()
+}
+
+// splitPattern splits the argument of Search into the pattern and
+// the directory. A pattern containing spaces can be enclosed in
+// slashes or quotes, in which the delimiter is escaped by a backslash.
+func splitPattern(arg string) (pattern, dir string, err error) {
+	if arg == "" {
+		return "", "", errors.New("missing pattern")
+	}
+	switch delim := arg[0]; delim {
+	case '/', '\'', '"':
+		var b []byte
+		for i := 1; i < len(arg); i++ {
+			switch c := arg[i]; {
+			case c == '\\' && i+1 < len(arg) && arg[i+1] == delim:
+				b = append(b, delim)
+				i++
+			case c == delim:
+				return string(b), strings.TrimSpace(arg[i+1:]), nil
+			default:
+				b = append(b, c)
+			}
+		}
+		return "", "", fmt.Errorf("missing closing %c", delim)
+	}
+	if i := strings.IndexAny(arg, " \t"); i >= 0 {
+		return arg[:i], strings.TrimSpace(arg[i:]), nil
+	}
+	return arg, "", nil
+}
+
+// searchFiles searches the files in the tree rooted at dir for rx
+// concurrently and writes the matching lines to w, in the order the
+// files are walked, as path:line:col: text with paths relative to dir.
+// Files ignored by .gitignore files and binary files are skipped.
+// The search stops early if stop is closed.
+func searchFiles(dir string, rx *regexp.Regexp, w io.Writer, stop <-chan struct{}) error {
+	type file struct {
+		seq  int
+		name string
+	}
+	type result struct {
+		seq int
+		out []byte
+	}
+	files := make(chan file)
+	results := make(chan result)
+
+	var walkErr error
+	go func() {
+		defer close(files)
+		seq := 0
+		walkErr = walkTree(dir, func(name string) bool {
+			select {
+			case files <- file{seq, name}:
+				seq++
+				return true
+			case <-stop:
+				return false
+			}
+		})
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < runtime.NumCPU(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for f := range files {
+				results <- result{f.seq, grepFile(dir, f.name, rx)}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// Results of files that are searched faster than the
+	// preceding ones wait in pending.
+	pending := make(map[int][]byte)
+	next := 0
+	for r := range results {
+		pending[r.seq] = r.out
+		for {
+			out, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			next++
+			if len(out) > 0 {
+				w.Write(out)
+			}
+		}
+	}
+	return walkErr
+}
+
+// walkTree calls fn with the slash-separated path relative to root
+// of each regular file in the tree that isn't ignored by the .gitignore
+// files. The walk stops if fn returns false.
+func walkTree(root string, fn func(name string) bool) error {
+	errStop := errors.New("stop")
+	var m gitignore.Matcher
+	err := filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+		rel, _ := filepath.Rel(root, path)
+		rel = filepath.ToSlash(rel)
+		if err != nil {
+			if rel == "." {
+				return err
+			}
+			// Skip unreadable files and directories.
+			return nil
+		}
+		if fi.IsDir() {
+			if rel != "." && (fi.Name() == ".git" || m.Match(rel, true)) {
+				return filepath.SkipDir
+			}
+			if f, err := os.Open(filepath.Join(path, ".gitignore")); err == nil {
+				m.Add(rel, f)
+				f.Close()
+			}
+			return nil
+		}
+		if !fi.Mode().IsRegular() || m.Match(rel, false) {
+			return nil
+		}
+		if !fn(rel) {
+			return errStop
+		}
+		return nil
+	})
+	if err == errStop {
+		return nil
+	}
+	return err
+}
+
+// grepFile returns the lines of the file name in the directory dir
+// matching rx formatted as name:line:col: text. It returns nil for
+// binary files and files that can't be read.
+func grepFile(dir, name string, rx *regexp.Regexp) []byte {
+	b, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+	if err != nil {
+		return nil
+	}
+	head := b
+	if len(head) > 8000 {
+		head = head[:8000]
+	}
+	if bytes.IndexByte(head, 0) >= 0 {
+		return nil
+	}
+	var out []byte
+	for n := 1; len(b) > 0; n++ {
+		line := b
+		if i := bytes.IndexByte(b, '\n'); i >= 0 {
+			line, b = b[:i], b[i+1:]
+		} else {
+			b = nil
+		}
+		line = bytes.TrimSuffix(line, []byte("\r"))
+		loc := rx.FindIndex(line)
+		if loc == nil {
+			continue
+		}
+		col := utf8.RuneCount(line[:loc[0]]) + 1
+		out = append(out, name...)
+		out = append(out, ':')
+		out = strconv.AppendInt(out, int64(n), 10)
+		out = append(out, ':')
+		out = strconv.AppendInt(out, int64(col), 10)
+		out = append(out, ": "...)
+		out = append(out, line...)
+		out = append(out, '\n')
+	}
+	return out
+}
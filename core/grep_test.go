@@ -0,0 +1,137 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSearch(t *testing.T) {
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		".gitignore":      "*.log\n/build/\n",
+		"main.go":         "package main\n\nfunc main() {\n\tprintln(\"hello\")\n}\n",
+		"debug.log":       "hello\n",
+		"build/out.txt":   "hello\n",
+		"lib/lib.go":      "package lib\n\n// Hello says hello.\nfunc Hello() {}\n",
+		"lib/.gitignore":  "gen/\n",
+		"lib/gen/x.go":    "hello\n",
+		"lib/žluť.txt":    "ž hello\r\n",
+		"data.bin":        "hello\x00\n",
+		".git/config":     "hello\n",
+		"docs/guide.md":   "Say hello\nand hello again\n",
+		"docs/empty.txt":  "",
+		"docs/nomatch.md": "bye\n",
+	}
+	for name, data := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ed := newTestEditor()
+	win := ed.recentCol().NewWindow()
+	win.SetFilename(filepath.Join(dir, "main.go"))
+	execute(win, "Search hel+o")
+	runEvents(t, func() bool { return len(ed.procs) == 0 })
+
+	res := ed.wins[filepath.Join(dir, "+Search")]
+	if res == nil {
+		t.Fatalf("no +Search window: %s", ed.errorWindow(dir).body.String())
+	}
+	want := []string{
+		"docs/guide.md:1:5: Say hello",
+		"docs/guide.md:2:5: and hello again",
+		"lib/lib.go:3:15: // Hello says hello.",
+		"lib/žluť.txt:1:3: ž hello",
+		"main.go:4:11: \tprintln(\"hello\")",
+	}
+	if got := strings.Split(strings.TrimSuffix(res.body.String(), "\n"), "\n"); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	execute(win, `Search /(?i)hello\(/ lib`)
+	runEvents(t, func() bool { return len(ed.procs) == 0 })
+	lib := ed.wins[filepath.Join(dir, "lib", "+Search")]
+	if lib == nil {
+		t.Fatal("no +Search window for lib")
+	}
+	if got, want := lib.body.String(), "lib.go:4:6: func Hello() {}\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// Searching again replaces the results.
+	execute(win, "Search 'func H'")
+	runEvents(t, func() bool { return len(ed.procs) == 0 })
+	if got, want := res.body.String(), "lib/lib.go:4:1: func Hello() {}\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// A search started while another one is running replaces it.
+	execute(win, "Search hel+o")
+	execute(win, "Search 'func H'")
+	if !ed.procs[0].killed {
+		t.Error("previous search not killed")
+	}
+	runEvents(t, func() bool { return len(ed.procs) == 0 })
+	if got, want := res.body.String(), "lib/lib.go:4:1: func Hello() {}\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	res.body.Plumb(3)
+	libgo := ed.lookupFile(filepath.Join(dir, "lib", "lib.go"))
+	if libgo == nil {
+		t.Fatal("plumbing a result didn't open the file")
+	}
+	if q0, q1 := libgo.body.Selected(); q0 != 34 || q1 != 34 {
+		t.Errorf("got selection %d,%d, want 34,34", q0, q1)
+	}
+
+	execute(win, "Search hel+o lib")
+	lib.Close()
+	if !ed.procs[0].killed {
+		t.Error("search not killed when its window was closed")
+	}
+	runEvents(t, func() bool { return len(ed.procs) == 0 })
+
+	execute(win, "Search (")
+	execute(win, "Search x /nonexistent")
+	if got := ed.errorWindow(dir).body.String(); !strings.Contains(got, "Search: error parsing regexp") ||
+		!strings.Contains(got, "Search: stat /nonexistent") {
+		t.Errorf("unexpected errors %q", got)
+	}
+}
+
+func TestSplitPattern(t *testing.T) {
+	tests := []struct {
+		arg, pattern, dir, err string
+	}{
+		{"foo", "foo", "", ""},
+		{"foo  src/x ", "foo", "src/x", ""},
+		{"/a b/ src", "a b", "src", ""},
+		{`/a\/b\.c/`, `a/b\.c`, "", ""},
+		{`'it''s'`, "it", "'s'", ""},
+		{`"a \"b\""`, `a "b"`, "", ""},
+		{"/abc", "", "", "missing closing /"},
+		{"", "", "", "missing pattern"},
+	}
+	for _, tt := range tests {
+		pattern, dir, err := splitPattern(tt.arg)
+		errs := ""
+		if err != nil {
+			errs = err.Error()
+		}
+		if pattern != tt.pattern || dir != tt.dir || errs != tt.err {
+			t.Errorf("%q: got %q, %q, %q, want %q, %q, %q", tt.arg, pattern, dir, errs, tt.pattern, tt.dir, tt.err)
+		}
+	}
+}
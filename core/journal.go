@@ -0,0 +1,461 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mibk/syd/ui"
+	"github.com/mibk/syd/undo"
+)
+
+// A journal records all operations performed on the undo.Buffer
+// of a window so that unsaved changes can be recovered if syd
+// crashes. Replaying the operations on the content the window
+// was opened with results in the same buffer, including its
+// undo history.
+//
+// The journal is kept in two files in the swap directory: the
+// base holding the original content, and the journal itself
+// consisting of a header with the filename and the operations.
+type journal struct {
+	path     string   // path of the journal without the extension
+	filename string   // absolute path of the edited file, or "" if unnamed
+	enc      Encoding // encoding of the file
+	base     []byte   // content the window was opened with
+
+	started bool // whether the files have been created
+	ops     bytes.Buffer
+}
+
+// Journal operations.
+const (
+	opInsert = 'i' // offset, length, data
+	opDelete = 'd' // offset, length
+	opCommit = 'c'
+	opUndo   = 'u'
+	opRedo   = 'r'
+	opClean  = 'p'
+)
+
+const journalMagic = "syd journal 1\n"
+
+// autosaveInterval specifies how often the journals are written.
+const autosaveInterval = 5 * time.Second
+
+// swapDir returns the directory holding the journals.
+func swapDir() (string, error) {
+	if dir := os.Getenv("SYDSWAP"); dir != "" {
+		return dir, nil
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "syd", "swap"), nil
+}
+
+// newJournal returns a journal of the file filename. The journal
+// of an unnamed window, whose filename is empty, is given a unique
+// path instead.
+func newJournal(filename string, enc Encoding, base []byte) (*journal, error) {
+	dir, err := swapDir()
+	if err != nil {
+		return nil, err
+	}
+	h := fnv.New64a()
+	abs := ""
+	if filename == "" {
+		fmt.Fprintf(h, "unnamed %d %d", os.Getpid(), time.Now().UnixNano())
+	} else {
+		if abs, err = filepath.Abs(filename); err != nil {
+			return nil, err
+		}
+		io.WriteString(h, abs)
+	}
+	return &journal{
+		path:     filepath.Join(dir, fmt.Sprintf("%x", h.Sum64())),
+		filename: abs,
+		enc:      enc,
+		base:     base,
+	}, nil
+}
+
+// name returns the name the journal is recovered by: the filename,
+// or the base of the path if unnamed.
+func (j *journal) name() string {
+	if j.filename == "" {
+		return filepath.Base(j.path)
+	}
+	return j.filename
+}
+
+func (j *journal) header() string {
+	return journalMagic + j.filename + "\n" + j.enc.String() + "\n"
+}
+
+func (j *journal) insert(off int64, s string) {
+	j.ops.WriteByte(opInsert)
+	j.putUvarint(uint64(off))
+	j.putUvarint(uint64(len(s)))
+	j.ops.WriteString(s)
+}
+
+func (j *journal) delete(off, n int64) {
+	j.ops.WriteByte(opDelete)
+	j.putUvarint(uint64(off))
+	j.putUvarint(uint64(n))
+}
+
+func (j *journal) op(op byte) { j.ops.WriteByte(op) }
+
+func (j *journal) putUvarint(x uint64) {
+	var b [binary.MaxVarintLen64]byte
+	j.ops.Write(b[:binary.PutUvarint(b[:], x)])
+}
+
+// flush appends the pending operations to the journal file.
+func (j *journal) flush() error {
+	if j.ops.Len() == 0 {
+		return nil
+	}
+	if !j.started {
+		if err := os.MkdirAll(filepath.Dir(j.path), 0700); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(j.path+".base", j.base, 0600); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(j.path+".journal", []byte(j.header()), 0600); err != nil {
+			return err
+		}
+		j.started = true
+	}
+	f, err := os.OpenFile(j.path+".journal", os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := j.ops.WriteTo(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// rename makes j the journal of the file filename, moving the
+// journal files already created.
+func (j *journal) rename(filename string) error {
+	nj, err := newJournal(filename, j.enc, j.base)
+	if err != nil {
+		return err
+	}
+	if nj.path == j.path {
+		return nil
+	}
+	if j.started {
+		b, err := ioutil.ReadFile(j.path + ".journal")
+		if err != nil {
+			return err
+		}
+		// Skip the old header; the filename is on its second line.
+		for i := 0; i < 3; i++ {
+			n := bytes.IndexByte(b, '\n')
+			if n < 0 {
+				return errors.New("not a journal")
+			}
+			b = b[n+1:]
+		}
+		if err := ioutil.WriteFile(nj.path+".journal", append([]byte(nj.header()), b...), 0600); err != nil {
+			return err
+		}
+		if err := os.Rename(j.path+".base", nj.path+".base"); err != nil {
+			os.Remove(nj.path + ".journal")
+			return err
+		}
+		os.Remove(j.path + ".journal")
+	}
+	j.path, j.filename = nj.path, nj.filename
+	return nil
+}
+
+// remove deletes the journal files.
+func (j *journal) remove() {
+	j.ops.Reset()
+	if j.started {
+		os.Remove(j.path + ".journal")
+		os.Remove(j.path + ".base")
+		j.started = false
+	}
+}
+
+// replayJournal reads the operations from r and performs them on
+// a buffer holding base.
+func replayJournal(r io.ByteReader, base []byte) (*undo.Buffer, error) {
+	buf := undo.NewBuffer(base)
+	for {
+		op, err := r.ReadByte()
+		if err == io.EOF {
+			return buf, nil
+		} else if err != nil {
+			return nil, err
+		}
+		switch op {
+		case opInsert, opDelete:
+			off, err := binary.ReadUvarint(r)
+			if err != nil {
+				return nil, unexpectedEOF(err)
+			}
+			n, err := binary.ReadUvarint(r)
+			if err != nil {
+				return nil, unexpectedEOF(err)
+			}
+			if op == opDelete {
+				err = buf.Delete(int64(off), int64(n))
+				break
+			}
+			data := make([]byte, n)
+			for i := range data {
+				if data[i], err = r.ReadByte(); err != nil {
+					return nil, unexpectedEOF(err)
+				}
+			}
+			err = buf.Insert(int64(off), data)
+		case opCommit:
+			buf.Commit()
+		case opUndo:
+			buf.Undo()
+		case opRedo:
+			buf.Redo()
+		case opClean:
+			buf.Clean()
+		default:
+			return nil, fmt.Errorf("unknown journal operation %q", op)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// A recoverable is an unsaved file found in the swap directory.
+type recoverable struct {
+	journal *journal
+	buf     *undo.Buffer
+}
+
+// readJournal reads and replays the journal at path (without
+// the extension).
+func readJournal(path string) (*recoverable, error) {
+	f, err := os.Open(path + ".journal")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	magic, err := r.ReadString('\n')
+	if err != nil || magic != journalMagic {
+		return nil, errors.New("not a journal")
+	}
+	filename, err := r.ReadString('\n')
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	encname, err := r.ReadString('\n')
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	enc, err := ParseEncoding(strings.TrimSuffix(encname, "\n"))
+	if err != nil {
+		return nil, err
+	}
+	base, err := ioutil.ReadFile(path + ".base")
+	if err != nil {
+		return nil, err
+	}
+	buf, err := replayJournal(r, base)
+	if err != nil {
+		return nil, err
+	}
+	return &recoverable{
+		journal: &journal{
+			path:     path,
+			filename: strings.TrimSuffix(filename, "\n"),
+			enc:      enc,
+			base:     base,
+			started:  true,
+		},
+		buf: buf,
+	}, nil
+}
+
+// findRecoverable returns the journals in the swap directory whose
+// files have unsaved changes. Journals of files that were saved are
+// removed.
+func findRecoverable() ([]*recoverable, error) {
+	dir, err := swapDir()
+	if err != nil {
+		return nil, err
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.journal"))
+	if err != nil {
+		return nil, err
+	}
+	var recs []*recoverable
+	for _, p := range paths {
+		rec, err := readJournal(strings.TrimSuffix(p, ".journal"))
+		if err != nil {
+			continue
+		}
+		if !rec.buf.Dirty() {
+			rec.journal.remove()
+			continue
+		}
+		recs = append(recs, rec)
+	}
+	sort.Slice(recs, func(i, j int) bool {
+		return recs[i].journal.name() < recs[j].journal.name()
+	})
+	return recs, nil
+}
+
+// autosave flushes the journals periodically until ed is closed.
+func (ed *Editor) autosave() {
+	t := time.NewTicker(autosaveInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-ed.done:
+			return
+		}
+		select {
+		case ui.Events <- ed.FlushJournals:
+		case <-ed.done:
+			return
+		}
+	}
+}
+
+// FlushJournals writes the pending operations of all windows
+// to their journals.
+func (ed *Editor) FlushJournals() {
+	for _, win := range ed.windows() {
+		j := win.buf.journal
+		if j == nil {
+			continue
+		}
+		if err := j.flush(); err != nil {
+			errorf(win, "journal of %s: %v\n", j.name(), err)
+			win.buf.journal = nil
+		}
+	}
+}
+
+// OfferRecovery reports files with unsaved changes left by a previous
+// syd session, if there are any.
+func (ed *Editor) OfferRecovery() {
+	recs, err := ed.recoverable()
+	if err != nil {
+		errorf(ed, "Recover: %v\n", err)
+		return
+	}
+	if len(recs) > 0 {
+		errorf(ed, "%d file(s) with unsaved changes found; execute Recover to list them\n", len(recs))
+	}
+}
+
+// recoverable returns the recoverable files that aren't open
+// in ed.
+func (ed *Editor) recoverable() ([]*recoverable, error) {
+	recs, err := findRecoverable()
+	if err != nil {
+		return nil, err
+	}
+	open := make(map[string]bool)
+	for _, win := range ed.windows() {
+		if j := win.buf.journal; j != nil {
+			open[j.path] = true
+		}
+	}
+	var other []*recoverable
+	for _, rec := range recs {
+		if !open[rec.journal.path] {
+			other = append(other, rec)
+		}
+	}
+	return other, nil
+}
+
+// recover lists the recoverable files if filename is empty,
+// otherwise it opens the file with the recovered changes.
+func (ed *Editor) recover(filename string) {
+	recs, err := ed.recoverable()
+	if err != nil {
+		errorf(ed, "Recover: %v\n", err)
+		return
+	}
+	if filename == "" {
+		if len(recs) == 0 {
+			errorf(ed, "Recover: nothing to recover\n")
+			return
+		}
+		win, ok := ed.wins["+Recover"]
+		if !ok {
+			win = ed.recentCol().NewWindow()
+			win.SetFilename("+Recover")
+		}
+		win.body.Select(0, win.buf.End())
+		for _, rec := range recs {
+			fmt.Fprintf(win, "Recover %s\n", rec.journal.name())
+		}
+		win.flush()
+		return
+	}
+
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		errorf(ed, "Recover: %v\n", err)
+		return
+	}
+	for _, rec := range recs {
+		j := rec.journal
+		if j.filename == "" && j.name() == filename {
+			win := ed.recentCol().newWindow(BytesContent(j.base))
+			win.buf.Buffer = rec.buf
+			win.buf.journal = j
+			win.enc, win.savedEnc = j.enc, j.enc
+			return
+		}
+		if j.filename != abs {
+			continue
+		}
+		if _, ok := ed.wins[j.filename]; ok {
+			errorf(ed, "Recover: %s is already open\n", j.filename)
+			return
+		}
+		win := ed.recentCol().newWindow(BytesContent(j.base))
+		win.buf.Buffer = rec.buf
+		win.buf.journal = j
+		win.enc, win.savedEnc = j.enc, j.enc
+		win.SetFilename(j.filename)
+		return
+	}
+	errorf(ed, "Recover: no unsaved changes of %s found\n", abs)
+}
@@ -0,0 +1,110 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/mibk/syd/undo"
+)
+
+func TestJournalReplay(t *testing.T) {
+	base := []byte("func main() {\n}\n")
+	j := &journal{base: base}
+	buf := NewUndoBuffer(undo.NewBuffer(base))
+	buf.journal = j
+	text := newText(nil, buf)
+
+	text.Select(14, 14)
+	text.Insert("\tprintln(\"hello\")\n")
+	buf.Commit()
+	text.Select(0, 4)
+	text.Insert("fn")
+	buf.Clean()
+	text.Select(4, 8)
+	text.DeleteSel()
+	buf.Undo()
+	buf.Undo()
+	buf.Redo()
+	text.Select(1, 1)
+	text.Insert("\xff")
+
+	got, err := replayJournal(bytes.NewReader(j.ops.Bytes()), base)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	checkSameBuffers(t, "#0", got, buf.Buffer)
+
+	for i := 0; i < 4; i++ {
+		got.Undo()
+		buf.Buffer.Undo()
+		checkSameBuffers(t, "#1", got, buf.Buffer)
+	}
+	got.Redo()
+	buf.Buffer.Redo()
+	checkSameBuffers(t, "#2", got, buf.Buffer)
+}
+
+func TestJournalTruncated(t *testing.T) {
+	j := &journal{}
+	j.insert(0, "hello")
+	b := j.ops.Bytes()
+	_, err := replayJournal(bytes.NewReader(b[:len(b)-1]), nil)
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("got %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+func TestJournalNewWindows(t *testing.T) {
+	t.Setenv("SYDSWAP", t.TempDir())
+	ed := newTestEditor()
+	col := ed.NewColumn()
+	errs := col.NewWindow()
+	errs.SetFilename("+Errors")
+	fmt.Fprintf(errs, "error\n")
+	errs.flush()
+	unnamed := col.NewWindow()
+	unnamed.body.Insert("draft")
+	named := col.NewWindow()
+	named.body.Insert("new file")
+	named.SetFilename(filepath.Join(t.TempDir(), "new.txt"))
+	ed.FlushJournals()
+
+	recs, err := NewEditor().recoverable()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(recs) != 2 {
+		t.Fatalf("got %d recoverable files, want 2", len(recs))
+	}
+	for _, rec := range recs {
+		want := unnamed
+		if rec.journal.filename != "" {
+			want = named
+			if rec.journal.filename != named.filename {
+				t.Errorf("got filename %q, want %q", rec.journal.filename, named.filename)
+			}
+		}
+		checkSameBuffers(t, rec.journal.name(), rec.buf, want.buf.Buffer)
+	}
+
+	ed.Close()
+	if recs, _ := NewEditor().recoverable(); len(recs) != 0 {
+		t.Errorf("got %d recoverable files after closing, want 0", len(recs))
+	}
+}
+
+func checkSameBuffers(t *testing.T, name string, got, want *undo.Buffer) {
+	t.Helper()
+	g, _ := ioutil.ReadAll(io.NewSectionReader(got, 0, got.Size()))
+	w, _ := ioutil.ReadAll(io.NewSectionReader(want, 0, want.Size()))
+	if !bytes.Equal(g, w) {
+		t.Errorf("%s: got %q, want %q", name, g, w)
+	}
+	if got.Dirty() != want.Dirty() {
+		t.Errorf("%s: got dirty %v, want %v", name, got.Dirty(), want.Dirty())
+	}
+}
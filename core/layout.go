@@ -0,0 +1,276 @@
+package core
+
+// The windows of a column are laid out from the top to the bottom:
+// each of them spans from its y to the y of the next one, or to 1.
+// The columns are laid out from the left to the right in the same way.
+
+// Default minimal sizes used until the UI sets them.
+const (
+	defaultLineHeight = 1.0 / 50
+	defaultMinWidth   = 1.0 / 20
+)
+
+// Ways of growing a window by GrowWindow.
+const (
+	GrowSome = iota // grow the window a bit
+	GrowMax         // leave only a line to each of the other windows
+	GrowFull        // hide the other windows
+)
+
+// SetLineHeight sets the height of a line as a fraction of the height
+// of the column. The layout keeps each window at least a line high
+// so that its tag is visible.
+func (col *Column) SetLineHeight(h float64) { col.lineHeight = h }
+
+func (col *Column) minHeight() float64 {
+	if col.lineHeight <= 0 {
+		return defaultLineHeight
+	}
+	return col.lineHeight
+}
+
+// SetMinColumnWidth sets the minimal width of a column as a fraction
+// of the width of the editor.
+func (ed *Editor) SetMinColumnWidth(w float64) { ed.minWidth = w }
+
+func (ed *Editor) minColumnWidth() float64 {
+	if ed.minWidth <= 0 {
+		return defaultMinWidth
+	}
+	return ed.minWidth
+}
+
+// GrowWindow makes the window win bigger, taking the space from the
+// other windows of the column, as described by how.
+func (col *Column) GrowWindow(win *Window, how int) {
+	wins := col.windows()
+	i := windowIndex(wins, win)
+	if i < 0 {
+		return
+	}
+	sizes := spanSizes(windowPositions(wins))
+	min := col.minHeight()
+	switch how {
+	case GrowSome:
+		grow(sizes, i, 0.1, min)
+	case GrowMax:
+		fillSpan(sizes, i, min)
+	case GrowFull:
+		fillSpan(sizes, i, 0)
+	}
+	for i, y := range spanPositions(sizes) {
+		wins[i].y = y
+	}
+}
+
+// ResizeWindow moves the top of the window win to y, resizing it and
+// the window above it. The windows keep at least a line of height.
+func (col *Column) ResizeWindow(win *Window, y float64) {
+	var prev *Window
+	for w := col.firstWin; w != win; w = w.next {
+		if w == nil {
+			return
+		}
+		prev = w
+	}
+	if prev == nil {
+		// The first window starts at the top.
+		return
+	}
+	min := col.minHeight()
+	if lo := prev.y + min; y < lo {
+		y = lo
+	}
+	if hi := win.bottom() - min; y > hi {
+		y = hi
+	}
+	if y >= prev.y && y <= win.bottom() {
+		win.y = y
+	}
+}
+
+// fit adjusts the positions of the windows so that each of them
+// is at least a line high.
+func (col *Column) fit() {
+	wins := col.windows()
+	sizes := spanSizes(windowPositions(wins))
+	fitSpans(sizes, col.minHeight())
+	for i, y := range spanPositions(sizes) {
+		wins[i].y = y
+	}
+}
+
+// GrowColumn makes the column col wider, taking the space from the
+// other columns. If max is true, the other columns are left only
+// with their minimal width.
+func (ed *Editor) GrowColumn(col *Column, max bool) {
+	cols := ed.columns()
+	i := -1
+	pos := make([]float64, len(cols))
+	for j, c := range cols {
+		if c == col {
+			i = j
+		}
+		pos[j] = c.x
+	}
+	if i < 0 {
+		return
+	}
+	sizes := spanSizes(pos)
+	min := ed.minColumnWidth()
+	if max {
+		fillSpan(sizes, i, min)
+	} else {
+		grow(sizes, i, 0.1, min)
+	}
+	for i, x := range spanPositions(sizes) {
+		cols[i].x = x
+	}
+}
+
+// ResizeColumn moves the left edge of the column col to x, resizing
+// it and the column on the left. The columns keep their minimal width.
+func (ed *Editor) ResizeColumn(col *Column, x float64) {
+	var prev *Column
+	for c := ed.firstCol; c != col; c = c.next {
+		if c == nil {
+			return
+		}
+		prev = c
+	}
+	if prev == nil {
+		return
+	}
+	min := ed.minColumnWidth()
+	if lo := prev.x + min; x < lo {
+		x = lo
+	}
+	if hi := col.right() - min; x > hi {
+		x = hi
+	}
+	if x >= prev.x && x <= col.right() {
+		col.x = x
+	}
+}
+
+// fitColumns adjusts the positions of the columns so that each
+// of them has its minimal width.
+func (ed *Editor) fitColumns() {
+	cols := ed.columns()
+	pos := make([]float64, len(cols))
+	for i, c := range cols {
+		pos[i] = c.x
+	}
+	sizes := spanSizes(pos)
+	fitSpans(sizes, ed.minColumnWidth())
+	for i, x := range spanPositions(sizes) {
+		cols[i].x = x
+	}
+}
+
+// columns returns the columns of the editor.
+func (ed *Editor) columns() []*Column {
+	var cols []*Column
+	for col := ed.firstCol; col != nil; col = col.next {
+		cols = append(cols, col)
+	}
+	return cols
+}
+
+func windowIndex(wins []*Window, win *Window) int {
+	for i, w := range wins {
+		if w == win {
+			return i
+		}
+	}
+	return -1
+}
+
+func windowPositions(wins []*Window) []float64 {
+	pos := make([]float64, len(wins))
+	for i, w := range wins {
+		pos[i] = w.y
+	}
+	return pos
+}
+
+// spanSizes returns the sizes of the spans that start at the
+// positions pos and end at the next position, or at 1.
+func spanSizes(pos []float64) []float64 {
+	sizes := make([]float64, len(pos))
+	for i := range pos {
+		end := 1.0
+		if i+1 < len(pos) {
+			end = pos[i+1]
+		}
+		sizes[i] = end - pos[i]
+		if sizes[i] < 0 {
+			sizes[i] = 0
+		}
+	}
+	return sizes
+}
+
+// spanPositions returns the positions of the spans of the sizes,
+// the first of which starts at 0.
+func spanPositions(sizes []float64) []float64 {
+	pos := make([]float64, len(sizes))
+	var p float64
+	for i, s := range sizes {
+		if p > 1 {
+			p = 1
+		}
+		pos[i] = p
+		p += s
+	}
+	return pos
+}
+
+// grow grows the span i by d taking the space from the biggest of
+// the other spans, which are left at least min.
+func grow(sizes []float64, i int, d, min float64) {
+	for d > 1e-9 {
+		j := -1
+		for k, s := range sizes {
+			if k != i && s > min && (j < 0 || s > sizes[j]) {
+				j = k
+			}
+		}
+		if j < 0 {
+			return
+		}
+		t := sizes[j] - min
+		if t > d {
+			t = d
+		}
+		sizes[j] -= t
+		sizes[i] += t
+		d -= t
+	}
+}
+
+// fillSpan grows the span i to the whole space but min left
+// for each of the other spans.
+func fillSpan(sizes []float64, i int, min float64) {
+	rest := 1.0
+	for k := range sizes {
+		if k != i {
+			sizes[k] = min
+			rest -= min
+		}
+	}
+	if rest < 0 {
+		rest = 0
+	}
+	sizes[i] = rest
+}
+
+// fitSpans grows the spans smaller than min taking the space
+// from the biggest ones.
+func fitSpans(sizes []float64, min float64) {
+	for i, s := range sizes {
+		if s < min {
+			grow(sizes, i, min-s, min)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package core
+
+import (
+	"math"
+	"testing"
+)
+
+func checkPositions(t *testing.T, what string, got []float64, want ...float64) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Errorf("%s: got positions %v, want %v", what, got, want)
+		return
+	}
+	for i := range got {
+		if math.Abs(got[i]-want[i]) > 1e-9 {
+			t.Errorf("%s: got positions %v, want %v", what, got, want)
+			return
+		}
+	}
+}
+
+func TestGrowWindow(t *testing.T) {
+	ed := newTestEditor()
+	col := ed.NewColumn()
+	col.SetLineHeight(0.05)
+	a, b, c := col.NewWindow(), col.NewWindow(), col.NewWindow()
+	positions := func() []float64 { return windowPositions(col.windows()) }
+	reset := func() {
+		a.SetY(0)
+		b.SetY(0.5)
+		c.SetY(0.8)
+	}
+
+	reset()
+	col.GrowWindow(c, GrowSome)
+	checkPositions(t, "grow some", positions(), 0, 0.4, 0.7)
+	col.GrowWindow(b, GrowMax)
+	checkPositions(t, "grow max", positions(), 0, 0.05, 0.95)
+	// In a column 20 lines high, the UI shows each window,
+	// including the last one, on at least a line.
+	const lines = 20
+	rows := func(y float64) int { return int(math.Round(y * lines)) }
+	wins := col.windows()
+	for i, w := range wins {
+		bottom := lines
+		if i+1 < len(wins) {
+			bottom = rows(wins[i+1].Y())
+		}
+		if bottom-rows(w.Y()) < 1 {
+			t.Errorf("grow max: window %d hidden in %v", i, positions())
+		}
+	}
+	col.GrowWindow(a, GrowFull)
+	checkPositions(t, "grow full", positions(), 0, 1, 1)
+
+	// The top of a window is kept a line from its neighbours.
+	reset()
+	col.ResizeWindow(c, 0.6)
+	checkPositions(t, "resize", positions(), 0, 0.5, 0.6)
+	col.ResizeWindow(c, 0.1)
+	checkPositions(t, "resize up", positions(), 0, 0.5, 0.55)
+	col.ResizeWindow(c, 2)
+	checkPositions(t, "resize down", positions(), 0, 0.5, 0.95)
+	col.ResizeWindow(a, 0.3)
+	checkPositions(t, "resize first", positions(), 0, 0.5, 0.95)
+
+	// Dropping a window at the top of another one moves them apart.
+	reset()
+	col.MoveWindow(a, 0.8)
+	if wins := col.windows(); wins[0] != b || wins[1] != c || wins[2] != a {
+		t.Errorf("got windows in the wrong order")
+	}
+	checkPositions(t, "move", positions(), 0, 0.75, 0.8)
+}
+
+func TestGrowColumn(t *testing.T) {
+	ed := newTestEditor()
+	ed.SetMinColumnWidth(0.1)
+	a, b := ed.NewColumn(), ed.NewColumn()
+	a.SetX(0)
+	b.SetX(0.5)
+	positions := func() []float64 {
+		return []float64{a.X(), b.X()}
+	}
+
+	ed.GrowColumn(a, false)
+	checkPositions(t, "grow", positions(), 0, 0.6)
+	ed.GrowColumn(b, true)
+	checkPositions(t, "grow max", positions(), 0, 0.1)
+	ed.ResizeColumn(b, 0.7)
+	checkPositions(t, "resize", positions(), 0, 0.7)
+	ed.ResizeColumn(b, 1)
+	checkPositions(t, "resize right", positions(), 0, 0.9)
+	ed.MoveColumn(b, 0)
+	checkPositions(t, "move", positions(), 0, 0.1)
+}
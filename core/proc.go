@@ -0,0 +1,364 @@
+package core
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/mibk/syd/ui"
+)
+
+// A proc is a running pipeline started by shellexec, or a built-in
+// command running in the background.
+type proc struct {
+	name   string // name of the first command
+	cmds   []*exec.Cmd
+	cancel func() // stops a built-in command
+	killed bool
+
+	input  *Window       // window whose body the pipeline reads, or nil
+	output *Window       // window a built-in command writes to, or nil
+	done   chan struct{} // closed when the reading pipeline has exited
+}
+
+// start starts the commands of the pipeline p in the directory dir
+// with env added to their environment. The output of each command
+// is connected to the input of the next one.
+func (p *pipeline) start(dir string, env []string, stdin io.Reader, stdout, stderr io.Writer) (*proc, error) {
+	var cmds []*command
+	for pp := p.pipe; pp != nil; pp = pp.prev {
+		cmds = append([]*command{pp.cmd}, cmds...)
+	}
+	pr := &proc{name: cmds[0].cmd}
+	if n := cmds[0].name; n != "" {
+		pr.name = n
+	}
+	for i, c := range cmds {
+		cmd := exec.Command(c.cmd, c.argv(dir)...)
+		cmd.Dir = dir
+		if env != nil {
+			cmd.Env = append(os.Environ(), env...)
+		}
+		cmd.Stderr = stderr
+		if i == 0 {
+			cmd.Stdin = stdin
+		} else {
+			out, err := pr.cmds[i-1].StdoutPipe()
+			if err != nil {
+				return nil, err
+			}
+			cmd.Stdin = out
+		}
+		pr.cmds = append(pr.cmds, cmd)
+	}
+	pr.cmds[len(pr.cmds)-1].Stdout = stdout
+
+	for i, cmd := range pr.cmds {
+		if err := cmd.Start(); err != nil {
+			for _, cmd := range pr.cmds[:i] {
+				cmd.Process.Kill()
+				cmd.Wait()
+			}
+			return nil, err
+		}
+	}
+	return pr, nil
+}
+
+// wait waits for all commands of the pipeline to exit and returns
+// the first error encountered.
+func (p *proc) wait() error {
+	var first error
+	for _, cmd := range p.cmds {
+		if err := cmd.Wait(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// kill kills all commands of the pipeline.
+func (p *proc) kill() {
+	if p.killed {
+		return
+	}
+	p.killed = true
+	if p.cancel != nil {
+		p.cancel()
+	}
+	for _, cmd := range p.cmds {
+		cmd.Process.Kill()
+	}
+}
+
+func (ed *Editor) addProc(p *proc) {
+	ed.procs = append(ed.procs, p)
+	ed.updateProcStatus()
+}
+
+func (ed *Editor) removeProc(p *proc) {
+	for i, pp := range ed.procs {
+		if pp == p {
+			ed.procs = append(ed.procs[:i], ed.procs[i+1:]...)
+			break
+		}
+	}
+	ed.updateProcStatus()
+}
+
+// updateProcStatus shows the names of the running commands
+// in the tag of the editor.
+func (ed *Editor) updateProcStatus() {
+	var names []string
+	for _, p := range ed.procs {
+		names = append(names, p.name)
+	}
+	status := strings.Join(names, " ")
+	if status != "" {
+		status += " "
+	}
+	ed.tag.setStatus(status)
+}
+
+// kill kills the running commands named by names, or all running
+// commands if names is empty.
+func (ed *Editor) kill(names []string) {
+	if len(names) == 0 {
+		for _, p := range ed.procs {
+			p.kill()
+		}
+		return
+	}
+	for _, name := range names {
+		found := false
+		for _, p := range ed.procs {
+			if p.name == name && !p.killed {
+				p.kill()
+				found = true
+			}
+		}
+		if !found {
+			errorf(ed, "Kill: no running command %s\n", name)
+		}
+	}
+}
+
+// A stream passes the output of a running command to the UI
+// goroutine as it arrives. It only splits the output at rune
+// boundaries so that runes aren't turned into raw bytes.
+type stream struct {
+	mu      sync.Mutex
+	pending []byte
+
+	write func(s string)  // called by the UI goroutine
+	done  func()          // called by the UI goroutine after the last write
+	stop  <-chan struct{} // closed when the output is no longer wanted
+}
+
+func (s *stream) Write(b []byte) (n int, err error) {
+	s.mu.Lock()
+	s.pending = append(s.pending, b...)
+	n = completeRunes(s.pending)
+	text := string(s.pending[:n])
+	s.pending = append(s.pending[:0], s.pending[n:]...)
+	s.mu.Unlock()
+	if n > 0 {
+		s.post(func() { s.write(text) })
+	}
+	return len(b), nil
+}
+
+// Close passes the rest of the output and calls done.
+func (s *stream) Close() error {
+	s.mu.Lock()
+	text := string(s.pending)
+	s.pending = nil
+	s.mu.Unlock()
+	s.post(func() {
+		if text != "" {
+			s.write(text)
+		}
+		if s.done != nil {
+			s.done()
+		}
+	})
+	return nil
+}
+
+// post sends fn to the UI goroutine unless stop is closed first.
+func (s *stream) post(fn func()) {
+	select {
+	case ui.Events <- fn:
+	case <-s.stop:
+	}
+}
+
+// completeRunes returns the length of the longest prefix of b
+// that doesn't end with an incomplete rune.
+func completeRunes(b []byte) int {
+	for i := len(b) - 1; i >= 0 && i >= len(b)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(b[i]) {
+			if !utf8.FullRune(b[i:]) {
+				return i
+			}
+			break
+		}
+	}
+	return len(b)
+}
+
+// errorStream returns a stream writing to the +Errors window
+// of the directory dir.
+func (ed *Editor) errorStream(dir string) *stream {
+	return &stream{
+		write: func(s string) {
+			w := ed.stderr(dir)
+			io.WriteString(w, s)
+			w.flush()
+		},
+		stop: ed.done,
+	}
+}
+
+// outputStream returns a stream replacing the range q0,q1 of the
+// body with the output as a single undoable change. If sel is true,
+// the output is selected afterwards, otherwise the selection and
+// the origin are kept where they were.
+func (win *Window) outputStream(q0, q1 int64, sel bool) *stream {
+	body := win.body
+	started := false
+	var end, oq0, oq1, org int64
+	insert := func(s string) {
+		if win.closed() {
+			return
+		}
+		if !started {
+			started = true
+			win.buf.Commit()
+			oq0, oq1 = body.Selected()
+			org = body.origin
+			body.Select(q0, q1)
+		} else {
+			body.Select(end, end)
+		}
+		body.Insert(s)
+		end = body.q1
+	}
+	return &stream{
+		write: insert,
+		done: func() {
+			if !started {
+				// Delete the range even if
+				// there is no output.
+				insert("")
+			}
+			if win.closed() {
+				return
+			}
+			win.buf.Commit()
+			if sel {
+				body.Select(q0, end)
+				return
+			}
+			clamp := func(q int64) int64 {
+				if e := win.buf.End(); q > e {
+					return e
+				}
+				return q
+			}
+			body.Select(clamp(oq0), clamp(oq1))
+			body.SetOrigin(body.PrevNewLine(clamp(org)+1, 1))
+		},
+		stop: win.stop,
+	}
+}
+
+// closed reports whether the window has been closed.
+func (win *Window) closed() bool {
+	select {
+	case <-win.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+func shellexec(ctx cmdContext, command string) {
+	ed := ctx.editor()
+	dir := ctxDir(ctx)
+	env := ctxEnv(ctx)
+
+	addr, command := splitAddr(command)
+	pipeln, err := parse(command, envLookup(env))
+	if err != nil {
+		if err != errEmptyCmd {
+			errorf(ctx, "syntax error: %v\n", err)
+		}
+		return
+	}
+
+	var stdin io.Reader
+	var input *Window
+	stderr := ed.errorStream(dir)
+	stdout := stderr
+	if pipeln.pipeInput || pipeln.pipeOutput {
+		win, ok := ctx.window()
+		if !ok {
+			errorf(ctx, "no current window\n")
+			return
+		}
+		// Without an address, the command operates on the
+		// selection, or on the default scope if there is no
+		// selection and the command reads it.
+		q0, q1 := win.body.Selected()
+		if addr == "" && q0 == q1 && pipeln.pipeInput {
+			addr = ed.scope
+		}
+		sel := addr == "" || addr == "."
+		if !sel {
+			if q0, q1, err = win.body.addr(addr); err != nil {
+				errorf(ctx, "address %s: %v\n", addr, err)
+				return
+			}
+		}
+		if pipeln.pipeInput {
+			stdin = win.body.reader(q0, q1)
+			input = win
+		}
+		if pipeln.pipeOutput {
+			stdout = win.outputStream(q0, q1, sel)
+		}
+	}
+
+	p, err := pipeln.start(dir, env, stdin, stdout, stderr)
+	if err != nil {
+		errorf(ctx, "%v\n", err)
+		return
+	}
+	if input != nil {
+		p.input, p.done = input, make(chan struct{})
+	}
+	ed.addProc(p)
+	go func() {
+		err := p.wait()
+		if p.done != nil {
+			close(p.done)
+		}
+		if stdout != stderr {
+			stdout.Close()
+		}
+		stderr.Close()
+		select {
+		case ui.Events <- func() {
+			ed.removeProc(p)
+			if err != nil && !p.killed {
+				errorf(ctx, "%s: %v\n", p.name, err)
+			}
+		}:
+		case <-ed.done:
+		}
+	}()
+}
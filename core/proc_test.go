@@ -0,0 +1,311 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/mibk/syd/ui"
+)
+
+// runEvents calls the functions sent to ui.Events, as the UI
+// would, until cond reports true.
+func runEvents(t testing.TB, cond func() bool) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for !cond() {
+		select {
+		case ev := <-ui.Events:
+			if fn, ok := ev.(func()); ok {
+				fn()
+			}
+		case <-timeout:
+			t.Fatal("timed out")
+		}
+	}
+}
+
+func TestAsyncExec(t *testing.T) {
+	ed := newTestEditor()
+	ed.tag.setText("Newcol Exit ")
+	win := ed.recentCol().NewWindow()
+	win.body.Insert("b\na\nc\n")
+	win.body.Select(0, win.buf.End())
+
+	execute(win, "|sort")
+	execute(ed, "echo out")
+	execute(ed, "ls /nonexistent/syd")
+	execute(ed, "sleep 10")
+	if got, want := ed.tag.String(), "Newcol Exit sort echo ls sleep "; got != want {
+		t.Errorf("got tag %q, want %q", got, want)
+	}
+	runEvents(t, func() bool { return len(ed.procs) == 1 })
+
+	if got, want := win.body.String(), "a\nb\nc\n"; got != want {
+		t.Errorf("got body %q, want %q", got, want)
+	}
+	if q0, q1 := win.body.Selected(); q0 != 0 || q1 != 6 {
+		t.Errorf("got selection %d,%d, want 0,6", q0, q1)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs := ed.errorWindow(wd).body.String()
+	for _, s := range []string{"out\n", "/nonexistent/syd", "ls: exit status 2\n"} {
+		if !strings.Contains(errs, s) {
+			t.Errorf("%q not found in +Errors %q", s, errs)
+		}
+	}
+
+	execute(ed, "Kill sort")
+	execute(ed, "Kill sleep")
+	runEvents(t, func() bool { return len(ed.procs) == 0 })
+	if got, want := ed.tag.String(), "Newcol Exit "; got != want {
+		t.Errorf("got tag %q, want %q", got, want)
+	}
+	if errs := ed.errorWindow(wd).body.String(); !strings.HasSuffix(errs, "Kill: no running command sort\n") {
+		t.Errorf("unexpected +Errors %q", errs)
+	}
+}
+
+func TestCloseKills(t *testing.T) {
+	ed := newTestEditor()
+	win := ed.recentCol().NewWindow()
+	execute(ed, "sleep 10")
+	execute(win, "<sleep 10")
+	if n := len(ed.procs); n != 2 {
+		t.Fatalf("got %d running commands, want 2", n)
+	}
+	procs := ed.procs
+	ed.Close()
+	// Nothing is sent to the UI after closing, so wait
+	// for the processes themselves.
+	timeout := time.After(5 * time.Second)
+	for _, p := range procs {
+		for p.cmds[0].Process.Signal(syscall.Signal(0)) == nil {
+			select {
+			case <-timeout:
+				t.Fatalf("%s not killed", p.name)
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}
+}
+
+func TestDelWhileReading(t *testing.T) {
+	t.Setenv("SYDSWAP", t.TempDir())
+	file := filepath.Join(t.TempDir(), "big.txt")
+	line := strings.Repeat("x", 99) + "\n"
+	if err := ioutil.WriteFile(file, []byte(strings.Repeat(line, 10000)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ed := newTestEditor()
+	win, err := ed.recentCol().NewWindowFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	win.body.Select(0, win.buf.End())
+
+	// The file is unmapped only after the command
+	// reading it has been killed.
+	execute(win, ">sh -c 'sleep 0.5; cat >/dev/null'")
+	execute(win, "Del")
+	runEvents(t, func() bool { return len(ed.procs) == 0 })
+}
+
+func TestExecShell(t *testing.T) {
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ed := newTestEditor()
+	win := ed.recentCol().NewWindow()
+	win.SetFilename(filepath.Join(dir, "main.go"))
+
+	// Commands using syntax only understood by a shell
+	// are run by $SHELL -c.
+	execute(win, "echo a && echo b; false || echo $((1+2))")
+	execute(win, "echo c > out.txt")
+	name := filepath.Join(dir, "+Errors")
+	runEvents(t, func() bool { return len(ed.procs) == 0 && ed.wins[name] != nil })
+	if got, want := ed.wins[name].body.String(), "a\nb\n3\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, "out.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b), "c\n"; got != want {
+		t.Errorf("got out.txt %q, want %q", got, want)
+	}
+}
+
+func TestExecDir(t *testing.T) {
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ed := newTestEditor()
+	win := ed.recentCol().NewWindow()
+	win.SetFilename(filepath.Join(dir, "main.go"))
+
+	execute(win, "pwd")
+	name := filepath.Join(dir, "+Errors")
+	runEvents(t, func() bool { return len(ed.procs) == 0 && ed.wins[name] != nil })
+	if got, want := ed.wins[name].body.String(), dir+"\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if n := len(ed.wins); n != 2 {
+		t.Errorf("got %d windows, want only %s and main.go", n, name)
+	}
+
+	errs := ed.wins[name]
+	errs.body.Select(0, errs.buf.End())
+	errs.body.DeleteSel()
+	win.body.Insert("package main\n")
+	win.body.Select(8, 12)
+	execute(win, "env")
+	runEvents(t, func() bool { return len(ed.procs) == 0 })
+	env := errs.body.String()
+	for _, v := range []string{
+		"samfile=" + win.filename,
+		"%=" + win.filename,
+		"winid=" + strconv.Itoa(win.id),
+		"sydaddr=#8,#12",
+	} {
+		if !strings.Contains(env, "\n"+v+"\n") {
+			t.Errorf("%s not found in the environment", v)
+		}
+	}
+}
+
+func TestPipeAddr(t *testing.T) {
+	ed := newTestEditor()
+	win := ed.recentCol().NewWindow()
+	win.body.Insert("c\nb\na\n--\nz\ny\n")
+	win.buf.Commit()
+	win.buf.Clean()
+
+	tests := []struct {
+		cmd    string
+		q0, q1 int64
+		want   string
+		sel    string
+	}{
+		// With nothing selected, the default scope is used
+		// and the cursor stays where it was.
+		{"|sort", 2, 2, "--\na\nb\nc\ny\nz\n", ""},
+		{",|sort -r", 4, 5, "z\ny\nc\nb\na\n--\n", "c"},
+		{"/c/,/--\\n/|sort", 0, 0, "z\ny\n--\na\nb\nc\n", ""},
+		{"|tr a-z A-Z", 0, 2, "Z\ny\n--\na\nb\nc\n", "Z\n"},
+		{"5<echo x", 0, 0, "Z\ny\n--\na\nx\nc\n", ""},
+	}
+	for _, tt := range tests {
+		win.body.Select(tt.q0, tt.q1)
+		execute(win, tt.cmd)
+		runEvents(t, func() bool { return len(ed.procs) == 0 })
+		if got := win.body.String(); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.cmd, got, tt.want)
+		}
+		if got := win.body.SelectionToString(win.body.Selected()); got != tt.sel {
+			t.Errorf("%s: got selection %q, want %q", tt.cmd, got, tt.sel)
+		}
+	}
+
+	// Each command is a single undo action.
+	want := []string{
+		"Z\ny\n--\na\nb\nc\n",
+		"z\ny\n--\na\nb\nc\n",
+		"z\ny\nc\nb\na\n--\n",
+		"--\na\nb\nc\ny\nz\n",
+		"c\nb\na\n--\nz\ny\n",
+	}
+	for _, w := range want {
+		execute(win, "Undo")
+		if got := win.body.String(); got != w {
+			t.Fatalf("after Undo: got %q, want %q", got, w)
+		}
+	}
+	if win.Dirty() {
+		t.Error("window dirty after undoing all commands")
+	}
+
+	ed.scope = "."
+	win.body.Select(0, 0)
+	execute(win, "|sort")
+	runEvents(t, func() bool { return len(ed.procs) == 0 })
+	if got, want := win.body.String(), "c\nb\na\n--\nz\ny\n"; got != want {
+		t.Errorf("with scope .: got %q, want %q", got, want)
+	}
+}
+
+func TestPipeInputSnapshot(t *testing.T) {
+	ed := newTestEditor()
+	win := ed.recentCol().NewWindow()
+	line := strings.Repeat("ž", 40) + "\xff\n"
+	win.body.Insert(strings.Repeat(line, 1<<14))
+	win.body.Select(42, win.buf.End())
+
+	execute(win, ">wc -c")
+	// The command must read the body as it was
+	// when it was started.
+	win.body.Select(0, win.buf.End())
+	win.body.Insert("changed")
+
+	runEvents(t, func() bool { return len(ed.procs) == 0 })
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := strconv.Itoa(len(line)*(1<<14) - 82)
+	if got := strings.TrimSpace(ed.errorWindow(wd).body.String()); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestCompleteRunes(t *testing.T) {
+	tests := []struct {
+		s    string
+		want int
+	}{
+		{"", 0},
+		{"abc", 3},
+		{"a\xc5", 1},
+		{"a\xc5\xbe", 3},
+		{"\xe2\x82", 0},
+		{"\xe2\x82\xac", 3},
+		{"a\xff", 2},
+		{"\x82\x82\x82\x82", 4},
+	}
+	for _, tt := range tests {
+		if got := completeRunes([]byte(tt.s)); got != tt.want {
+			t.Errorf("%q: got %d, want %d", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestStreamClosedWindow(t *testing.T) {
+	ed := newTestEditor()
+	win := ed.NewColumn().NewWindow()
+	out := win.outputStream(0, 0, true)
+	win.Close()
+	done := make(chan struct{})
+	go func() {
+		// Nothing receives the events, so the stream
+		// must give up once the window is closed.
+		out.Write([]byte("output"))
+		out.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("writing to a closed window blocked")
+	}
+}
@@ -0,0 +1,132 @@
+package core
+
+import (
+	"os"
+	"os/exec"
+	"sync"
+)
+
+// A ptyCmd is a command running on a pseudo-terminal.
+type ptyCmd struct {
+	cmd    *exec.Cmd
+	master *os.File
+
+	// The input is queued so that sending it never blocks
+	// the UI goroutine, even if the command doesn't read it.
+	mu     sync.Mutex
+	input  [][]byte
+	closed bool
+	ready  chan struct{} // signals queued input
+	done   chan struct{} // closed by close
+}
+
+// startPty starts the command args in the directory dir on a new
+// pseudo-terminal of the size cols×rows with env added to its
+// environment. If lineMode is true, the terminal doesn't echo the
+// input nor translate newlines, as the typed text is edited and
+// shown by the window.
+func startPty(dir string, args, env []string, cols, rows int, lineMode bool) (*ptyCmd, error) {
+	master, slave, err := openPty()
+	if err != nil {
+		return nil, err
+	}
+	defer slave.Close()
+	if lineMode {
+		err = setLineMode(slave)
+	}
+	if err == nil {
+		err = setPtySize(master, cols, rows)
+	}
+	if err != nil {
+		master.Close()
+		return nil, err
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), env...)
+	if err := startOnPty(cmd, slave); err != nil {
+		master.Close()
+		return nil, err
+	}
+	p := &ptyCmd{
+		cmd:    cmd,
+		master: master,
+		ready:  make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	go p.writeInput()
+	return p, nil
+}
+
+// readOutput passes the output of the command to write until the
+// command exits, which is then reported to exited. Both functions
+// are called by the UI goroutine, unless stop is closed.
+func (p *ptyCmd) readOutput(stop <-chan struct{}, write func(s string), exited func(err error)) {
+	out := &stream{write: write, stop: stop}
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := p.master.Read(buf)
+		if n > 0 {
+			out.Write(buf[:n])
+		}
+		if err != nil {
+			break
+		}
+	}
+	err := p.cmd.Wait()
+	out.done = func() { exited(err) }
+	out.Close()
+}
+
+// writeInput writes the input sent to the command to the terminal
+// until it is closed. Input sent after the command has exited is
+// discarded.
+func (p *ptyCmd) writeInput() {
+	for {
+		select {
+		case <-p.ready:
+		case <-p.done:
+			return
+		}
+		p.mu.Lock()
+		input := p.input
+		p.input = nil
+		p.mu.Unlock()
+		for _, b := range input {
+			p.master.Write(b)
+		}
+	}
+}
+
+// send queues b to be written to the input of the command.
+// It does nothing once the terminal is closed.
+func (p *ptyCmd) send(b []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return
+	}
+	p.input = append(p.input, b)
+	select {
+	case p.ready <- struct{}{}:
+	default:
+	}
+}
+
+// close closes the terminal. If the command is still running, its
+// session is hung up.
+func (p *ptyCmd) close(running bool) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed, p.input = true, nil
+	p.mu.Unlock()
+	close(p.done)
+	p.master.Close()
+	if running {
+		hangup(p.cmd.Process)
+	}
+}
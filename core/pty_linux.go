@@ -0,0 +1,65 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// openPty opens a new pseudo-terminal.
+func openPty() (master, slave *os.File, err error) {
+	master, err = os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	fd := int(master.Fd())
+	if err := unix.IoctlSetPointerInt(fd, unix.TIOCSPTLCK, 0); err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("unlockpt: %v", err)
+	}
+	n, err := unix.IoctlGetInt(fd, unix.TIOCGPTN)
+	if err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("ptsname: %v", err)
+	}
+	slave, err = os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	return master, slave, nil
+}
+
+// setLineMode turns off the echo of the terminal and the translation
+// of newlines to CRLF.
+func setLineMode(slave *os.File) error {
+	tio, err := unix.IoctlGetTermios(int(slave.Fd()), unix.TCGETS)
+	if err != nil {
+		return err
+	}
+	tio.Lflag &^= unix.ECHO
+	tio.Oflag &^= unix.ONLCR
+	return unix.IoctlSetTermios(int(slave.Fd()), unix.TCSETS, tio)
+}
+
+// setPtySize sets the size of the pseudo-terminal in characters.
+func setPtySize(master *os.File, cols, rows int) error {
+	ws := &unix.Winsize{Col: uint16(cols), Row: uint16(rows)}
+	return unix.IoctlSetWinsize(int(master.Fd()), unix.TIOCSWINSZ, ws)
+}
+
+// startOnPty starts cmd in a new session with the slave side
+// of a pseudo-terminal as its controlling terminal.
+func startOnPty(cmd *exec.Cmd, slave *os.File) error {
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = slave, slave, slave
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true}
+	return cmd.Start()
+}
+
+// hangup sends SIGHUP to the session led by p.
+func hangup(p *os.Process) {
+	syscall.Kill(-p.Pid, syscall.SIGHUP)
+}
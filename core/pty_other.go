@@ -0,0 +1,24 @@
+//go:build !linux
+// +build !linux
+
+package core
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+func openPty() (master, slave *os.File, err error) {
+	return nil, nil, errors.New("pseudo-terminals are not supported on this system")
+}
+
+func setLineMode(slave *os.File) error { return nil }
+
+func setPtySize(master *os.File, cols, rows int) error { return nil }
+
+func startOnPty(cmd *exec.Cmd, slave *os.File) error {
+	return errors.New("pseudo-terminals are not supported on this system")
+}
+
+func hangup(p *os.Process) { p.Kill() }
@@ -0,0 +1,96 @@
+package core
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+
+	"github.com/mibk/syd/remote"
+	"github.com/mibk/syd/ui"
+)
+
+// Open opens the file in the most recent column and selects the
+// address addr in it. If the file is already open, its window is
+// used instead.
+func (ed *Editor) Open(filename, addr string) (*Window, error) {
+	win := ed.lookupFile(filename)
+	if win == nil {
+		var err error
+		win, err = ed.recentCol().NewWindowFile(filename)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if addr != "" {
+		if err := win.SelectAddr(addr); err != nil {
+			return win, fmt.Errorf("%s: %v", filename, err)
+		}
+	}
+	return win, nil
+}
+
+// lookupFile returns the window editing filename, or nil.
+func (ed *Editor) lookupFile(filename string) *Window {
+	if win, ok := ed.wins[filename]; ok {
+		return win
+	}
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return nil
+	}
+	for name, win := range ed.wins {
+		if a, err := filepath.Abs(name); err == nil && a == abs {
+			return win
+		}
+	}
+	return nil
+}
+
+// ServeRemote opens the files requested by other syd processes
+// connecting to l. It returns when l is closed.
+func (ed *Editor) ServeRemote(l net.Listener) error {
+	return remote.Serve(l, func(req *remote.Request, done chan<- error) {
+		ui.Events <- func() { ed.openRemote(req, done) }
+	})
+}
+
+func (ed *Editor) openRemote(req *remote.Request, done chan<- error) {
+	var wins []*Window
+	var firstErr error
+	for _, f := range req.Files {
+		var win *Window
+		var err error
+		if strings.HasPrefix(f.Name, "+") {
+			win = ed.recentCol().NewWindowContent(f.Name, f.Data)
+		} else if win, err = ed.Open(f.Name, f.Addr); err != nil {
+			errorf(ed, "%v\n", err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+		if win == nil {
+			continue
+		}
+		if req.ReadOnly {
+			win.SetReadOnly(true)
+		}
+		wins = append(wins, win)
+	}
+	if !req.Wait || len(wins) == 0 {
+		done <- firstErr
+		return
+	}
+	open := len(wins)
+	for _, win := range wins {
+		win := win
+		win.OnClose(func(dirty bool) {
+			if req.Edit && (dirty || !win.Saved()) && firstErr == nil {
+				firstErr = fmt.Errorf("%s not saved", win.filename)
+			}
+			if open--; open == 0 {
+				done <- firstErr
+			}
+		})
+	}
+}
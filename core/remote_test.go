@@ -0,0 +1,107 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mibk/syd/remote"
+)
+
+func TestOpenRemote(t *testing.T) {
+	t.Setenv("SYDSWAP", t.TempDir())
+	file := filepath.Join(t.TempDir(), "a.txt")
+	if err := ioutil.WriteFile(file, []byte("one\ntwo\nthree\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The already open window must be found by any name.
+	rel, err := filepath.Rel(wd, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ed := newTestEditor()
+	win, err := ed.recentCol().NewWindowFile(rel)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	ed.openRemote(&remote.Request{
+		Files: []remote.File{
+			{Name: file, Addr: "2"},
+			{Name: "+stdin", Data: []byte("piped\n")},
+		},
+		Wait: true,
+	}, done)
+	if n := len(ed.wins); n != 2 {
+		t.Fatalf("got %d windows, want 2", n)
+	}
+	if got := win.body.SelectionToString(win.body.Selected()); got != "two\n" {
+		t.Errorf("got selection %q, want %q", got, "two\n")
+	}
+	stdin := ed.wins["+stdin"]
+	if got := stdin.body.String(); got != "piped\n" {
+		t.Errorf("got +stdin %q, want %q", got, "piped\n")
+	}
+
+	win.Close()
+	select {
+	case <-done:
+		t.Fatal("request done before all windows were closed")
+	default:
+	}
+	stdin.Close()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	default:
+		t.Fatal("request not done after all windows were closed")
+	}
+}
+
+func TestOpenRemoteEdit(t *testing.T) {
+	t.Setenv("SYDSWAP", t.TempDir())
+	file := filepath.Join(t.TempDir(), "COMMIT_EDITMSG")
+	for _, save := range []bool{false, true} {
+		ed := newTestEditor()
+		done := make(chan error, 1)
+		ed.openRemote(&remote.Request{
+			Files: []remote.File{{Name: file}},
+			Wait:  true,
+			Edit:  true,
+		}, done)
+		win := ed.wins[file]
+		if save {
+			win.body.Insert("Fix typo\n")
+			if err := win.saveFile(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		win.Close()
+		if err := <-done; (err == nil) != save {
+			t.Errorf("save=%v: got error %v", save, err)
+		}
+	}
+}
+
+func TestCloseFromOnClose(t *testing.T) {
+	ed := newTestEditor()
+	win := ed.recentCol().NewWindow()
+	calls := 0
+	win.OnClose(func(bool) {
+		calls++
+		win.Close()
+	})
+	win.Close()
+	win.Close()
+	if calls != 1 {
+		t.Errorf("OnClose called %d times, want 1", calls)
+	}
+}
@@ -0,0 +1,232 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// replace runs the Replace command:
+//
+//	Replace [-n] [-w | -a] /re/repl/
+//
+// It replaces all matches of the regular expression re with repl,
+// in which $1 or ${name} stand for the submatches, $$ for a dollar
+// sign, \n for a newline and \t for a tab. Another punctuation
+// character, such as | or :, can be used as the delimiter instead
+// of /; it's escaped inside re and repl by a backslash. The
+// replacement is done in the selection, or in the default scope if
+// nothing is selected. With -w, the whole body is used, and with -a,
+// the bodies of all open files. With -n, the matches are only counted
+// and reported. The changes to each window are undone at once.
+func replace(ctx cmdContext, arg string) {
+	ed := ctx.editor()
+	dryRun, all, whole := false, false, false
+	for strings.HasPrefix(arg, "-") {
+		var flag string
+		flag, arg = arg, ""
+		if i := strings.IndexAny(flag, " \t"); i >= 0 {
+			flag, arg = flag[:i], strings.TrimSpace(flag[i:])
+		}
+		switch flag {
+		case "-n":
+			dryRun = true
+		case "-a":
+			all = true
+		case "-w":
+			whole = true
+		default:
+			errorf(ctx, "Replace: unknown flag %s\n", flag)
+			return
+		}
+	}
+	re, repl, err := splitReplace(arg)
+	if err != nil {
+		errorf(ctx, "Replace: %v\n", err)
+		return
+	}
+	rx, err := regexp.Compile("(?m)" + re)
+	if err != nil {
+		errorf(ctx, "Replace: %v\n", err)
+		return
+	}
+
+	var wins []*Window
+	if all {
+		for _, win := range ed.windows() {
+			if isFileName(win.filename) && !win.readOnly && win.term == nil {
+				wins = append(wins, win)
+			}
+		}
+	} else {
+		win, ok := ctx.window()
+		if !ok {
+			errorf(ctx, "Replace: no current window\n")
+			return
+		}
+		if win.readOnly || win.term != nil {
+			errorf(ctx, "Replace: %s is read-only\n", win.filename)
+			return
+		}
+		wins = append(wins, win)
+	}
+
+	total, nwins := 0, 0
+	for _, win := range wins {
+		q0, q1 := win.body.Selected()
+		sel := !all && !whole && q0 != q1
+		if !sel && !all && !whole {
+			if q0, q1, err = win.body.addr(ed.scope); err != nil {
+				errorf(ctx, "Replace: address %s: %v\n", ed.scope, err)
+				return
+			}
+		} else if !sel {
+			q0, q1 = 0, win.buf.End()
+		}
+		n := win.replace(rx, repl, q0, q1, sel, dryRun)
+		if n == 0 {
+			continue
+		}
+		total += n
+		nwins++
+		if dryRun {
+			errorf(ctx, "Replace: %s: %s\n", win.filename, plural(n, "match", "matches"))
+		}
+	}
+	switch {
+	case total == 0:
+		errorf(ctx, "Replace: no match for %q\n", re)
+	case dryRun && all:
+		errorf(ctx, "Replace: %s in %s\n", plural(total, "match", "matches"), plural(nwins, "window", "windows"))
+	}
+}
+
+// plural formats n followed by the singular or the plural form.
+func plural(n int, singular, pl string) string {
+	if n == 1 {
+		return "1 " + singular
+	}
+	return fmt.Sprintf("%d %s", n, pl)
+}
+
+// splitReplace splits the argument of Replace, /re/repl/, into
+// the regular expression and the replacement template.
+func splitReplace(arg string) (re, repl string, err error) {
+	if arg == "" {
+		return "", "", errors.New("missing pattern")
+	}
+	delim := arg[0]
+	if !strings.ContainsRune("/!\"#%&'*+,.:;<=>?@^_`|~", rune(delim)) {
+		return "", "", fmt.Errorf("bad delimiter %c", delim)
+	}
+	var parts []string
+	var b []byte
+	for i := 1; i < len(arg); i++ {
+		switch c := arg[i]; {
+		case c == '\\' && i+1 < len(arg):
+			i++
+			switch c := arg[i]; {
+			case c == delim:
+				b = append(b, delim)
+			case len(parts) == 0:
+				b = append(b, '\\', c)
+			case c == 'n':
+				b = append(b, '\n')
+			case c == 't':
+				b = append(b, '\t')
+			case c == '\\':
+				b = append(b, c)
+			default:
+				b = append(b, '\\', c)
+			}
+		case c == delim:
+			parts = append(parts, string(b))
+			b = nil
+			if len(parts) == 2 {
+				if rest := strings.TrimSpace(arg[i+1:]); rest != "" {
+					return "", "", fmt.Errorf("unexpected %q", rest)
+				}
+				return parts[0], parts[1], nil
+			}
+		default:
+			b = append(b, c)
+		}
+	}
+	if len(parts) == 0 {
+		return "", "", errors.New("missing replacement")
+	}
+	// The closing delimiter can be omitted.
+	return parts[0], string(b), nil
+}
+
+// replace replaces the matches of rx in the range q0,q1 of the
+// body with the template repl expanded as by regexp.Expand and
+// returns the number of matches. If dryRun is true, the matches are
+// only counted. If sel is true, the changed range is selected
+// afterwards, otherwise the selection and the origin are kept where
+// they were.
+func (win *Window) replace(rx *regexp.Regexp, repl string, q0, q1 int64, sel, dryRun bool) int {
+	b, err := ioutil.ReadAll(win.body.reader(q0, q1))
+	if err != nil {
+		errorf(win, "Replace: %v\n", err)
+		return 0
+	}
+	matches := rx.FindAllSubmatchIndex(b, -1)
+	if dryRun || len(matches) == 0 {
+		return len(matches)
+	}
+
+	type edit struct {
+		q0, q1 int64
+		s      string
+	}
+	edits := make([]edit, len(matches))
+	q, off := q0, 0
+	for i, m := range matches {
+		q += int64(utf8.RuneCount(b[off:m[0]]))
+		e := edit{q0: q}
+		q += int64(utf8.RuneCount(b[m[0]:m[1]]))
+		e.q1 = q
+		e.s = string(rx.Expand(nil, []byte(repl), b, m))
+		edits[i] = e
+		off = m[1]
+	}
+
+	body := win.body
+	oq0, oq1, org := body.q0, body.q1, body.origin
+	end := q1
+	win.buf.Commit()
+	// Edit from the end so that the positions of the
+	// preceding matches stay valid.
+	for i := len(edits) - 1; i >= 0; i-- {
+		e := edits[i]
+		n := int64(utf8.RuneCountInString(e.s))
+		if sh := body.shell(); sh != nil {
+			sh.edited(e.q0, e.q1, n)
+		}
+		win.buf.Delete(e.q0, e.q1)
+		win.buf.Insert(e.q0, e.s)
+		shift := func(q int64) int64 {
+			switch {
+			case q >= e.q1:
+				return q + n - (e.q1 - e.q0)
+			case q > e.q0:
+				return e.q0
+			}
+			return q
+		}
+		oq0, oq1, org = shift(oq0), shift(oq1), shift(org)
+		end += n - (e.q1 - e.q0)
+	}
+	win.buf.Commit()
+	if sel {
+		body.Select(q0, end)
+		return len(matches)
+	}
+	body.Select(oq0, oq1)
+	body.SetOrigin(body.PrevNewLine(org+1, 1))
+	return len(matches)
+}
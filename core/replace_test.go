@@ -0,0 +1,93 @@
+package core
+
+import "testing"
+
+func TestReplace(t *testing.T) {
+	ed := newTestEditor()
+	col := ed.recentCol()
+	a := col.NewWindow()
+	a.SetFilename("/src/a.go")
+	a.body.Insert("foo(1)\nbar(2)\nfoo(3)\n")
+	a.buf.Commit()
+	b := col.NewWindow()
+	b.SetFilename("/src/b.go")
+	b.body.Insert("ž foo(4)\n")
+	b.buf.Commit()
+	errs := col.NewWindow()
+	errs.SetFilename("/src/+Errors")
+	errs.body.Insert("foo(5)\n")
+
+	// Without a selection, the whole body is used.
+	a.body.Select(9, 9)
+	execute(a, `Replace /foo\((\d)\)/baz[$1]/`)
+	if got, want := a.body.String(), "baz[1]\nbar(2)\nbaz[3]\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if q0, q1 := a.body.Selected(); q0 != 9 || q1 != 9 {
+		t.Errorf("got selection %d,%d, want 9,9", q0, q1)
+	}
+	a.body.Select(a.buf.Undo())
+	if got, want := a.body.String(), "foo(1)\nbar(2)\nfoo(3)\n"; got != want {
+		t.Errorf("undo: got %q, want %q", got, want)
+	}
+
+	// The selection is replaced and selected.
+	a.body.Select(7, 21)
+	execute(a, `Replace |(\w+)\(|$1\n(|`)
+	if got, want := a.body.String(), "foo(1)\nbar\n(2)\nfoo\n(3)\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if q0, q1 := a.body.Selected(); q0 != 7 || q1 != 23 {
+		t.Errorf("got selection %d,%d, want 7,23", q0, q1)
+	}
+	a.body.Select(a.buf.Undo())
+
+	execute(a, `Replace -n -a /foo/X/`)
+	execute(a, `Replace -a /foo/X/`)
+	execute(a, `Replace /nothing/X/`)
+	if got, want := a.body.String(), "X(1)\nbar(2)\nX(3)\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := b.body.String(), "ž X(4)\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	// +Errors isn't a file, so it's left alone.
+	want := "foo(5)\n" +
+		"Replace: /src/a.go: 2 matches\n" +
+		"Replace: /src/b.go: 1 match\n" +
+		"Replace: 3 matches in 2 windows\n" +
+		"Replace: no match for \"nothing\"\n"
+	if got := errs.body.String(); got != want {
+		t.Errorf("got errors %q, want %q", got, want)
+	}
+	b.body.Select(b.buf.Undo())
+	if got, want := b.body.String(), "ž foo(4)\n"; got != want {
+		t.Errorf("undo: got %q, want %q", got, want)
+	}
+}
+
+func TestSplitReplace(t *testing.T) {
+	tests := []struct {
+		arg, re, repl, err string
+	}{
+		{"/a/b/", "a", "b", ""},
+		{"/a/b", "a", "b", ""},
+		{"/a//", "a", "", ""},
+		{`/a\/b\./c\/d\n\t\\\x/`, `a/b\.`, "c/d\n\t\\\\x", ""},
+		{`|a/b|$1|`, "a/b", "$1", ""},
+		{"/a/b/ c", "", "", `unexpected "c"`},
+		{"/a", "", "", "missing replacement"},
+		{"abc", "", "", "bad delimiter a"},
+		{"", "", "", "missing pattern"},
+	}
+	for _, tt := range tests {
+		re, repl, err := splitReplace(tt.arg)
+		errs := ""
+		if err != nil {
+			errs = err.Error()
+		}
+		if re != tt.re || repl != tt.repl || errs != tt.err {
+			t.Errorf("%q: got %q, %q, %q, want %q, %q, %q", tt.arg, re, repl, errs, tt.re, tt.repl, tt.err)
+		}
+	}
+}
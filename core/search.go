@@ -0,0 +1,404 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"unicode/utf8"
+)
+
+// A searchQuery is what an incremental search looks for.
+type searchQuery struct {
+	text   string
+	regexp bool // text is a regular expression
+	fold   bool // case-insensitive
+}
+
+func (sq searchQuery) compile() (*regexp.Regexp, error) {
+	re := sq.text
+	if !sq.regexp {
+		re = regexp.QuoteMeta(re)
+	}
+	if sq.fold {
+		re = "(?i)" + re
+	}
+	return regexp.Compile("(?m)" + re)
+}
+
+// searchCountSize is the size of the largest text in which all
+// matches of an incremental search are counted. In bigger texts,
+// only the matches around the selection and the visible ones are
+// looked for.
+const searchCountSize = 1 << 20
+
+// A search is an incremental search in a text. The matches are
+// looked for in the direction of the search from the selected one,
+// so that the search doesn't read the whole text on every step.
+type search struct {
+	searchQuery
+	dir  int   // 1 forward, -1 backward
+	from int64 // position the search continues from
+
+	// selection and origin before the search
+	q0, q1, origin int64
+
+	rx      *regexp.Regexp // nil if the query is empty or invalid
+	match   [2]int64       // the selected match
+	found   bool           // a match is selected
+	wrapped bool           // the selected match was reached by wrapping around
+	err     error          // the query isn't a valid regular expression
+
+	count int   // number of matches, or -1 if they aren't counted
+	index int   // index of the selected match, if counted
+	end   int64 // end of the text when the matches were counted
+}
+
+// StartSearch starts an incremental search forward (dir > 0) or
+// backward (dir < 0) from the selection. If the search is already
+// running, the next match in the direction is selected.
+func (t *Text) StartSearch(dir int) {
+	if t.search != nil {
+		t.SearchNext(dir)
+		return
+	}
+	last := t.ctx.editor().lastSearch
+	t.search = &search{
+		searchQuery: searchQuery{regexp: last.regexp, fold: last.fold},
+		dir:         sign(dir),
+		from:        t.q0,
+		q0:          t.q0,
+		q1:          t.q1,
+		origin:      t.origin,
+	}
+	t.showSearch()
+}
+
+// Searching reports whether an incremental search is running.
+func (t *Text) Searching() bool { return t.search != nil }
+
+// SearchNext selects the next match in the direction dir, wrapping
+// around the ends of the text. If the query is empty, the last one
+// is searched for.
+func (t *Text) SearchNext(dir int) {
+	s := t.search
+	if s == nil {
+		return
+	}
+	s.dir = sign(dir)
+	if s.text == "" {
+		if last := t.ctx.editor().lastSearch; last.text != "" {
+			s.searchQuery = last
+			t.updateSearch()
+		}
+		return
+	}
+	if s.rx == nil {
+		return
+	}
+	m, wrapped, ok := t.nextMatch()
+	if !ok {
+		s.found = false
+		return
+	}
+	s.match, s.found, s.wrapped = m, true, wrapped
+	s.from = m[0]
+	t.countMatches()
+	t.Select(m[0], m[1])
+	t.showSearch()
+}
+
+// nextMatch returns the match the search continues to. The first
+// match is the first one starting at or after s.from when searching
+// forward, or the last one starting at or before it when searching
+// backward; the following ones are those after or before the selected
+// match. It wraps around the ends of the text if there is no such match.
+func (t *Text) nextMatch() (m [2]int64, wrapped, ok bool) {
+	s := t.search
+	end := t.buf.End()
+	if s.dir > 0 {
+		from := s.from
+		if s.found {
+			from = s.match[1]
+			if s.match[0] == s.match[1] {
+				from++
+			}
+		}
+		if m, ok := t.findNext(s.rx, from); ok {
+			return m, false, true
+		}
+		m, ok := t.findNext(s.rx, 0)
+		return m, true, ok
+	}
+	before := s.from + 1
+	if s.found {
+		before = s.match[0]
+	}
+	if before > end {
+		before = end
+	}
+	if m, ok := t.findPrev(s.rx, before); ok {
+		return m, false, true
+	}
+	m, ok = t.findPrev(s.rx, end)
+	return m, true, ok
+}
+
+// SearchAppend appends str to the query.
+func (t *Text) SearchAppend(str string) {
+	if s := t.search; s != nil {
+		s.text += str
+		t.updateSearch()
+	}
+}
+
+// SearchDelete deletes the last character of the query.
+func (t *Text) SearchDelete() {
+	if s := t.search; s != nil && s.text != "" {
+		_, size := utf8.DecodeLastRuneInString(s.text)
+		s.text = s.text[:len(s.text)-size]
+		t.updateSearch()
+	}
+}
+
+// ToggleSearchRegexp toggles whether the query is a regular
+// expression.
+func (t *Text) ToggleSearchRegexp() {
+	if s := t.search; s != nil {
+		s.regexp = !s.regexp
+		t.updateSearch()
+	}
+}
+
+// ToggleSearchCase toggles whether the search is case-insensitive.
+func (t *Text) ToggleSearchCase() {
+	if s := t.search; s != nil {
+		s.fold = !s.fold
+		t.updateSearch()
+	}
+}
+
+// StopSearch stops the incremental search. If accept is false, the
+// selection is restored to what it was before the search.
+func (t *Text) StopSearch(accept bool) {
+	s := t.search
+	if s == nil {
+		return
+	}
+	t.search = nil
+	if !accept {
+		t.Select(s.q0, s.q1)
+		t.SetOrigin(s.origin)
+	}
+	if s.text != "" {
+		t.ctx.editor().lastSearch = s.searchQuery
+	}
+	if tag := t.statusTag(); tag != nil {
+		tag.setStatus("")
+	}
+}
+
+// SearchMatches returns the matches of the running incremental
+// search that overlap the range q0,q1. Only the lines of the range
+// are searched, so it's meant for the visible part of the text.
+func (t *Text) SearchMatches(q0, q1 int64) [][2]int64 {
+	s := t.search
+	if s == nil || s.rx == nil {
+		return nil
+	}
+	if s.found && s.end != t.buf.End() {
+		// The text has been modified by a command.
+		t.countMatches()
+		t.showSearch()
+	}
+	var matches [][2]int64
+	for _, m := range t.findIn(s.rx, t.lineStart(q0), t.lineEnd(q1)) {
+		if m[1] > q0 && m[0] < q1 {
+			matches = append(matches, m)
+		}
+	}
+	return matches
+}
+
+// updateSearch compiles a changed query and selects the first match
+// from where the search continues in its direction.
+func (t *Text) updateSearch() {
+	s := t.search
+	s.rx, s.err = nil, nil
+	s.found, s.wrapped = false, false
+	if s.text != "" {
+		s.rx, s.err = s.compile()
+	}
+	if s.rx != nil {
+		t.SearchNext(s.dir)
+	}
+	if !s.found {
+		t.Select(s.q0, s.q1)
+		t.SetOrigin(s.origin)
+	}
+	t.showSearch()
+}
+
+// countMatches counts the matches and finds the index of the selected
+// one if the text is small enough.
+func (t *Text) countMatches() {
+	s := t.search
+	s.count, s.end = -1, t.buf.End()
+	if buf, ok := t.buf.(*UndoBuffer); ok && buf.Size() > searchCountSize {
+		return
+	}
+	matches := t.findIn(s.rx, 0, s.end)
+	s.count = len(matches)
+	s.index = sort.Search(len(matches), func(i int) bool { return matches[i][0] >= s.match[0] })
+}
+
+// showSearch shows the state of the search in the tag of the window,
+// e.g. /foo/ri 3/17 wrapped, where r stands for a regular expression
+// and i for a case-insensitive search.
+func (t *Text) showSearch() {
+	tag := t.statusTag()
+	if tag == nil {
+		return
+	}
+	s := t.search
+	flags := ""
+	if s.regexp {
+		flags += "r"
+	}
+	if s.fold {
+		flags += "i"
+	}
+	status := fmt.Sprintf("/%s/%s", s.text, flags)
+	switch {
+	case s.err != nil:
+		status += " bad regexp"
+	case s.text == "":
+	case !s.found:
+		status += " no match"
+	default:
+		if s.count >= 0 {
+			status += fmt.Sprintf(" %d/%d", s.index+1, s.count)
+		}
+		if s.wrapped {
+			status += " wrapped"
+		}
+	}
+	tag.setStatus(status + " ")
+}
+
+// statusTag returns the tag of the window if t is its body.
+func (t *Text) statusTag() *Text {
+	if t.ctx == nil {
+		return nil
+	}
+	win, ok := t.ctx.window()
+	if !ok || win.body != t {
+		return nil
+	}
+	return win.tag
+}
+
+// findNext returns the first non-empty match of rx starting at or
+// after q. The text after q is searched in growing chunks, so that
+// the matches close to q are found without reading the whole text.
+func (t *Text) findNext(rx *regexp.Regexp, q int64) (m [2]int64, ok bool) {
+	end := t.buf.End()
+	for size := int64(1 << 12); ; size *= 2 {
+		to := q + size
+		if to > end {
+			to = end
+		}
+		to = t.lineEnd(to)
+		for _, m := range t.findIn(rx, t.lineStart(q), to) {
+			// A match reaching the end of the chunk
+			// could continue after it.
+			if m[0] >= q && (m[1] < to || to == end) {
+				return m, true
+			}
+		}
+		if to == end {
+			return m, false
+		}
+	}
+}
+
+// findPrev returns the last non-empty match of rx starting before q.
+// The text before q is searched in growing chunks, so that the matches
+// close to q are found without reading the whole text.
+func (t *Text) findPrev(rx *regexp.Regexp, q int64) (m [2]int64, ok bool) {
+	for size := int64(1 << 12); ; size *= 2 {
+		from := q - size
+		if from < 0 {
+			from = 0
+		}
+		from = t.lineStart(from)
+		// A match starting before q can end after it.
+		matches := t.findIn(rx, from, t.lineEnd(q))
+		for i := len(matches) - 1; i >= 0; i-- {
+			if matches[i][0] < q {
+				return matches[i], true
+			}
+		}
+		if from == 0 {
+			return m, false
+		}
+	}
+}
+
+// findIn returns the ranges of the non-empty matches of rx in the
+// part of the text from q0 to q1.
+func (t *Text) findIn(rx *regexp.Regexp, q0, q1 int64) [][2]int64 {
+	b := t.bytes(q0, q1)
+	var matches [][2]int64
+	q := q0 // position of the byte at off
+	off := 0
+	for _, loc := range rx.FindAllIndex(b, -1) {
+		if loc[0] == loc[1] {
+			continue
+		}
+		// Invalid bytes are counted as one rune each,
+		// the same as they are decoded by the buffers.
+		q += int64(utf8.RuneCount(b[off:loc[0]]))
+		m0 := q
+		q += int64(utf8.RuneCount(b[loc[0]:loc[1]]))
+		off = loc[1]
+		matches = append(matches, [2]int64{m0, q})
+	}
+	return matches
+}
+
+// bytes returns the bytes of the runes from q0 to q1.
+func (t *Text) bytes(q0, q1 int64) []byte {
+	buf, ok := t.buf.(*UndoBuffer)
+	if !ok {
+		return []byte(t.SelectionToString(q0, q1))
+	}
+	off0 := buf.setPos(q0)
+	off1 := buf.setPos(q1)
+	b := make([]byte, off1-off0)
+	if _, err := buf.Buffer.ReadAt(b, off0); err != nil && err != io.EOF {
+		panic(err)
+	}
+	return b
+}
+
+// lineEnd returns the position of the end of the line containing q,
+// including the newline.
+func (t *Text) lineEnd(q int64) int64 {
+	for {
+		switch t.readRuneAt(q) {
+		case EOF:
+			return q
+		case '\n':
+			return q + 1
+		}
+		q++
+	}
+}
+
+func sign(n int) int {
+	if n < 0 {
+		return -1
+	}
+	return 1
+}
@@ -0,0 +1,117 @@
+package core
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestIncrementalSearch(t *testing.T) {
+	ed := newTestEditor()
+	win := ed.recentCol().NewWindow()
+	body := win.body
+	body.Insert("foo Bar foo\nfoo bar ž.r")
+	body.Select(5, 5)
+
+	check := func(q0, q1 int64, status string) {
+		t.Helper()
+		if g0, g1 := body.Selected(); g0 != q0 || g1 != q1 {
+			t.Errorf("got selection %d,%d, want %d,%d", g0, g1, q0, q1)
+		}
+		if tag := win.tag.String(); !strings.HasSuffix(tag, " "+status+" ") {
+			t.Errorf("got tag %q, want status %q", tag, status)
+		}
+	}
+
+	body.StartSearch(1)
+	check(5, 5, "//")
+	body.SearchAppend("f")
+	body.SearchAppend("oo")
+	check(8, 11, "/foo/ 2/3")
+	body.SearchNext(1)
+	check(12, 15, "/foo/ 3/3")
+	body.SearchNext(1)
+	check(0, 3, "/foo/ 1/3 wrapped")
+	body.SearchNext(-1)
+	check(12, 15, "/foo/ 3/3 wrapped")
+	body.SearchNext(-1)
+	check(8, 11, "/foo/ 2/3")
+	body.SearchAppend("x")
+	check(5, 5, "/foox/ no match")
+	body.SearchDelete()
+	check(8, 11, "/foo/ 2/3")
+
+	if got, want := body.SearchMatches(2, 9), [][2]int64{{0, 3}, {8, 11}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got matches %v, want %v", got, want)
+	}
+	body.StopSearch(false)
+	check(5, 5, "Redo")
+	if body.Searching() || body.SearchMatches(0, 100) != nil {
+		t.Error("search still running")
+	}
+
+	body.StartSearch(-1)
+	body.SearchNext(-1)
+	check(0, 3, "/foo/ 1/3")
+	body.StopSearch(true)
+	check(0, 3, "Redo")
+
+	body.Select(23, 23)
+	body.StartSearch(-1)
+	body.SearchAppend("b.r")
+	check(23, 23, "/b.r/ no match")
+	body.ToggleSearchRegexp()
+	check(16, 19, "/b.r/r 1/1")
+	body.ToggleSearchCase()
+	check(16, 19, "/b.r/ri 2/2")
+	body.SearchNext(-1)
+	check(4, 7, "/b.r/ri 1/2")
+	body.SearchDelete()
+	body.SearchDelete()
+	body.SearchDelete()
+	body.SearchAppend(`ž\.`)
+	check(20, 22, `/ž\./ri 1/1 wrapped`)
+	body.SearchAppend("(")
+	check(23, 23, `/ž\.(/ri bad regexp`)
+	body.StopSearch(true)
+}
+
+func TestIncrementalSearchLarge(t *testing.T) {
+	ed := newTestEditor()
+	win := ed.recentCol().NewWindow()
+	body := win.body
+	line := strings.Repeat("x", 99) + "\n"
+	n := int64(searchCountSize/len(line) + 1)
+	body.Insert("foo\n" + strings.Repeat(line, int(n)) + "foo\n")
+	end := body.buf.End()
+	body.Select(10, 10)
+
+	check := func(q0, q1 int64, status string) {
+		t.Helper()
+		if g0, g1 := body.Selected(); g0 != q0 || g1 != q1 {
+			t.Errorf("got selection %d,%d, want %d,%d", g0, g1, q0, q1)
+		}
+		if tag := win.tag.String(); !strings.HasSuffix(tag, " "+status+" ") {
+			t.Errorf("got tag %q, want status %q", tag, status)
+		}
+	}
+
+	// The matches aren't counted in a large text.
+	body.StartSearch(-1)
+	body.SearchAppend("foo")
+	check(0, 3, "/foo/")
+	body.SearchNext(-1)
+	check(end-4, end-1, "/foo/ wrapped")
+	body.SearchNext(-1)
+	check(0, 3, "/foo/")
+	body.SearchNext(1)
+	check(end-4, end-1, "/foo/")
+
+	if got := body.SearchMatches(100, 200); got != nil {
+		t.Errorf("got matches %v, want none", got)
+	}
+	if got, want := body.SearchMatches(end-10, end), [][2]int64{{end - 4, end - 1}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got matches %v, want %v", got, want)
+	}
+	body.StopSearch(true)
+}
@@ -0,0 +1,337 @@
+package core
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// A text can have several selections. The primary one, q0,q1, is
+// used by most operations; Insert, DeleteSel, Snarf, Cut and Paste
+// act on all of them. Selecting a range by Select, or by the mouse,
+// leaves only the primary selection.
+
+// Selections returns all the selections of the text in the order
+// they appear in it.
+func (t *Text) Selections() [][2]int64 {
+	sels, _ := t.selections()
+	return sels
+}
+
+// selections returns all the selections, sorted, and the index
+// of the primary one.
+func (t *Text) selections() (sels [][2]int64, primary int) {
+	sels = make([][2]int64, 0, len(t.extra)+1)
+	sels = append(sels, t.extra...)
+	p := [2]int64{t.q0, t.q1}
+	primary = sort.Search(len(sels), func(i int) bool {
+		return sels[i][0] > p[0] || sels[i][0] == p[0] && sels[i][1] >= p[1]
+	})
+	sels = append(sels, [2]int64{})
+	copy(sels[primary+1:], sels[primary:])
+	sels[primary] = p
+	return sels, primary
+}
+
+// setSelections sets the selections of the text to sels, of which
+// the primary one is at the index primary. Overlapping selections
+// are merged.
+func (t *Text) setSelections(sels [][2]int64, primary int) {
+	p := sels[primary]
+	sort.Slice(sels, func(i, j int) bool {
+		return sels[i][0] < sels[j][0] || sels[i][0] == sels[j][0] && sels[i][1] < sels[j][1]
+	})
+	t.extra = t.extra[:0]
+	for _, s := range sels {
+		if n := len(t.extra); n > 0 {
+			last := &t.extra[n-1]
+			if s[0] < last[1] || s[0] == last[0] && s[0] == last[1] {
+				if s[1] > last[1] {
+					last[1] = s[1]
+				}
+				continue
+			}
+		}
+		t.extra = append(t.extra, s)
+	}
+	// The primary selection is the original primary selection,
+	// or the one it has been merged into.
+	i := -1
+	for j, s := range t.extra {
+		if s == p {
+			i = j
+			break
+		}
+		if i < 0 && s[0] <= p[0] && p[1] <= s[1] {
+			i = j
+		}
+	}
+	if i < 0 {
+		i = 0
+	}
+	t.q0, t.q1 = t.extra[i][0], t.extra[i][1]
+	t.extra = append(t.extra[:i], t.extra[i+1:]...)
+}
+
+// replaceSelections replaces each selection with the text returned by
+// text for its index in the sorted selections and leaves the cursor
+// after the inserted text.
+func (t *Text) replaceSelections(text func(i int) string) {
+	sels, primary := t.selections()
+	n := make([]int64, len(sels))
+	// Replace from the end so that the positions of the
+	// preceding selections stay valid.
+	for i := len(sels) - 1; i >= 0; i-- {
+		q0, q1 := sels[i][0], sels[i][1]
+		s := text(i)
+		n[i] = int64(utf8.RuneCountInString(s))
+		if sh := t.shell(); sh != nil {
+			sh.edited(q0, q1, n[i])
+		}
+		if q0 != q1 {
+			t.buf.Delete(q0, q1)
+		}
+		if s != "" {
+			t.buf.Insert(q0, s)
+		}
+	}
+	var delta int64
+	for i, s := range sels {
+		q := s[0] + delta + n[i]
+		delta += n[i] - (s[1] - s[0])
+		sels[i] = [2]int64{q, q}
+	}
+	t.setSelections(sels, primary)
+}
+
+// moveSelections moves the selections at or after q by n runes
+// after n runes have been inserted at q.
+func (t *Text) moveSelections(q, n int64) {
+	move := func(p *int64) {
+		if *p >= q {
+			*p += n
+		}
+	}
+	move(&t.q0)
+	move(&t.q1)
+	for i := range t.extra {
+		move(&t.extra[i][0])
+		move(&t.extra[i][1])
+	}
+}
+
+// ExtendEmpty extends each empty selection by the rune before it
+// if dir is negative, or by the rune after it otherwise.
+func (t *Text) ExtendEmpty(dir int) {
+	sels, primary := t.selections()
+	end := t.buf.End()
+	for i, s := range sels {
+		switch {
+		case s[0] != s[1]:
+		case dir < 0 && s[0] > 0:
+			sels[i][0]--
+		case dir >= 0 && s[1] < end:
+			sels[i][1]++
+		}
+	}
+	t.setSelections(sels, primary)
+}
+
+// selectedText returns the text of the selections separated
+// by newlines.
+func (t *Text) selectedText() string {
+	var texts []string
+	for _, s := range t.Selections() {
+		texts = append(texts, t.SelectionToString(s[0], s[1]))
+	}
+	return strings.Join(texts, "\n")
+}
+
+// A block is a rectangular selection being made by the mouse.
+type block struct {
+	anchor int64
+	width  func(r rune, col int) int
+}
+
+// StartBlock starts selecting a block, a rectangle of text, at q.
+// The display width of the rune r at the column col is returned by
+// width. The block is extended by MoveSel as a selection on each line.
+func (t *Text) StartBlock(q int64, width func(r rune, col int) int) {
+	t.StartSel(q)
+	t.block = &block{anchor: q, width: width}
+}
+
+// selectBlock selects the block between the positions of the anchor
+// and q: the runes of each line between them that are displayed
+// between their columns. The selection on the line of q is the
+// primary one.
+func (t *Text) selectBlock(q int64) {
+	b := t.block
+	col := func(q int64) int {
+		x := 0
+		for p := t.lineStart(q); p < q; p++ {
+			x += b.width(t.readRuneAt(p), x)
+		}
+		return x
+	}
+	x0, x1 := col(b.anchor), col(q)
+	if x0 > x1 {
+		x0, x1 = x1, x0
+	}
+	first, last := b.anchor, q
+	if first > last {
+		first, last = last, first
+	}
+
+	var sels [][2]int64
+	primary := 0
+	for p := t.lineStart(first); ; {
+		if p <= q {
+			primary = len(sels)
+		}
+		// Select the runes overlapping the columns x0 to x1,
+		// or put the cursor at x0 if the block is empty.
+		q0, q1 := int64(-1), int64(-1)
+		x := 0
+		for {
+			r := t.readRuneAt(p)
+			if r == '\n' || r == EOF {
+				break
+			}
+			w := b.width(r, x)
+			if q0 < 0 && (x+w > x0 && x < x1 || x >= x0) {
+				q0 = p
+			}
+			if q1 < 0 && x >= x1 && q0 >= 0 {
+				q1 = p
+			}
+			x += w
+			p++
+		}
+		if q0 < 0 {
+			q0 = p
+		}
+		if q1 < 0 {
+			q1 = p
+		}
+		sels = append(sels, [2]int64{q0, q1})
+		if p >= last || t.readRuneAt(p) == EOF {
+			break
+		}
+		p++
+	}
+	t.setSelections(sels, primary)
+}
+
+// lineStart returns the position of the beginning of the line
+// containing q.
+func (t *Text) lineStart(q int64) int64 {
+	for q > 0 && t.readRuneAt(q-1) != '\n' {
+		q--
+	}
+	return q
+}
+
+// addSelection selects the next occurrence of the text of the
+// primary selection after the last selection, keeping the other
+// selections, and makes it the primary one. An empty selection is
+// extended to the word around it instead.
+func (t *Text) addSelection() error {
+	if t.q0 == t.q1 {
+		q0, q1 := t.dblclick(t.q0)
+		if q0 == q1 {
+			return errors.New("no selection")
+		}
+		sels, primary := t.selections()
+		sels[primary] = [2]int64{q0, q1}
+		t.setSelections(sels, primary)
+		return nil
+	}
+	rx := regexp.MustCompile(regexp.QuoteMeta(t.SelectionToString(t.q0, t.q1)))
+	sels, _ := t.selections()
+	start := sels[len(sels)-1][1]
+	from, wrapped := start, false
+	for {
+		q0, q1, ok := t.findForward(rx, from, false)
+		if !ok || wrapped && q0 >= start {
+			if wrapped {
+				return errors.New("no more matches")
+			}
+			from, wrapped = 0, true
+			continue
+		}
+		i := sort.Search(len(sels), func(i int) bool { return sels[i][1] > q0 })
+		if i == len(sels) || sels[i][0] >= q1 {
+			sels = append(sels, [2]int64{q0, q1})
+			t.setSelections(sels, len(sels)-1)
+			return nil
+		}
+		// Skip the matches that are already selected.
+		from = q1
+	}
+}
+
+// splitSelections replaces each selection by its lines without the
+// final newlines, or by the matches of the regular expression re if
+// it isn't empty. If there is only an empty selection, the default
+// scope of the editor is split instead.
+func (t *Text) splitSelections(re string) error {
+	var rx *regexp.Regexp
+	if re != "" {
+		var err error
+		if rx, err = regexp.Compile("(?m)" + re); err != nil {
+			return err
+		}
+	}
+	sels, _ := t.selections()
+	if len(sels) == 1 && t.q0 == t.q1 {
+		scope := t.ctx.editor().scope
+		q0, q1, err := t.addr(scope)
+		if err != nil {
+			return err
+		}
+		sels[0] = [2]int64{q0, q1}
+	}
+
+	var split [][2]int64
+	for _, s := range sels {
+		text := t.SelectionToString(s[0], s[1])
+		var locs [][]int
+		if rx != nil {
+			locs = rx.FindAllStringIndex(text, -1)
+		} else {
+			locs = lineLocs(text)
+		}
+		q, off := s[0], 0
+		for _, loc := range locs {
+			q += int64(utf8.RuneCountInString(text[off:loc[0]]))
+			q0 := q
+			q += int64(utf8.RuneCountInString(text[loc[0]:loc[1]]))
+			split = append(split, [2]int64{q0, q})
+			off = loc[1]
+		}
+	}
+	if len(split) == 0 {
+		return errors.New("no match")
+	}
+	t.setSelections(split, 0)
+	return nil
+}
+
+// lineLocs returns the locations of the lines of s without
+// the final newlines.
+func lineLocs(s string) [][]int {
+	var locs [][]int
+	for off := 0; off < len(s); {
+		i := strings.IndexByte(s[off:], '\n')
+		if i < 0 {
+			locs = append(locs, []int{off, len(s)})
+			break
+		}
+		locs = append(locs, []int{off, off + i})
+		off += i + 1
+	}
+	return locs
+}
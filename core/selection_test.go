@@ -0,0 +1,154 @@
+package core
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMultipleSelections(t *testing.T) {
+	ed := newTestEditor()
+	win := ed.recentCol().NewWindow()
+	body := win.body
+	body.Insert("foo bar\nfoo baz\nžfoo\n")
+	win.buf.Commit()
+
+	check := func(text string, sels ...[2]int64) {
+		t.Helper()
+		if got := body.String(); got != text {
+			t.Errorf("got text %q, want %q", got, text)
+		}
+		if got := body.Selections(); !reflect.DeepEqual(got, sels) {
+			t.Errorf("got selections %v, want %v", got, sels)
+		}
+	}
+
+	body.Select(1, 1)
+	execute(win, "Addsel")
+	check("foo bar\nfoo baz\nžfoo\n", [2]int64{0, 3})
+	execute(win, "Addsel")
+	execute(win, "Addsel")
+	check("foo bar\nfoo baz\nžfoo\n", [2]int64{0, 3}, [2]int64{8, 11}, [2]int64{17, 20})
+	if q0, q1 := body.Selected(); q0 != 17 || q1 != 20 {
+		t.Errorf("got primary selection %d,%d, want 17,20", q0, q1)
+	}
+	execute(win, "Addsel")
+	if got := ed.errorWindow(win.dir()).body.String(); !strings.Contains(got, "Addsel: no more matches") {
+		t.Errorf("got errors %q", got)
+	}
+	if got, want := body.selectedText(), "foo\nfoo\nfoo"; got != want {
+		t.Errorf("got selected text %q, want %q", got, want)
+	}
+
+	body.Insert("xy")
+	check("xy bar\nxy baz\nžxy\n", [2]int64{2, 2}, [2]int64{9, 9}, [2]int64{17, 17})
+	body.ExtendEmpty(-1)
+	body.DeleteSel()
+	check("x bar\nx baz\nžx\n", [2]int64{1, 1}, [2]int64{7, 7}, [2]int64{14, 14})
+	body.ExtendEmpty(1)
+	check("x bar\nx baz\nžx\n", [2]int64{1, 2}, [2]int64{7, 8}, [2]int64{14, 15})
+
+	// All the changes are undone at once.
+	body.Select(win.buf.Undo())
+	check("foo bar\nfoo baz\nžfoo\n", [2]int64{17, 20})
+
+	body.Select(0, 15)
+	execute(win, "Splitsel")
+	check("foo bar\nfoo baz\nžfoo\n", [2]int64{0, 7}, [2]int64{8, 15})
+	execute(win, `Splitsel ba.`)
+	check("foo bar\nfoo baz\nžfoo\n", [2]int64{4, 7}, [2]int64{12, 15})
+	body.Insert("\n")
+	check("foo \n\nfoo \n\nžfoo\n", [2]int64{5, 5}, [2]int64{11, 11})
+	execute(win, "Onesel")
+	check("foo \n\nfoo \n\nžfoo\n", [2]int64{5, 5})
+
+	// Without a selection, the default scope is split.
+	execute(win, `Splitsel o+`)
+	check("foo \n\nfoo \n\nžfoo\n", [2]int64{1, 3}, [2]int64{7, 9}, [2]int64{14, 16})
+	body.StartSel(0)
+	check("foo \n\nfoo \n\nžfoo\n", [2]int64{0, 0})
+}
+
+func TestSetSelections(t *testing.T) {
+	var body Text
+	body.setSelections([][2]int64{{5, 5}, {1, 4}, {3, 6}, {8, 8}, {8, 8}, {8, 9}, {10, 10}}, 3)
+	if got, want := body.Selections(), [][2]int64{{1, 6}, {8, 9}, {10, 10}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got selections %v, want %v", got, want)
+	}
+	if q0, q1 := body.Selected(); q0 != 8 || q1 != 9 {
+		t.Errorf("got primary selection %d,%d, want 8,9", q0, q1)
+	}
+}
+
+func TestBlockSelection(t *testing.T) {
+	ed := newTestEditor()
+	win := ed.recentCol().NewWindow()
+	body := win.body
+	body.Insert("a\tb1\nxyzžw\n\nab\tc2\n")
+	width := func(r rune, col int) int {
+		if r == '\t' {
+			return 8 - col%8
+		}
+		return 1
+	}
+
+	check := func(sels ...[2]int64) {
+		t.Helper()
+		if got := body.Selections(); !reflect.DeepEqual(got, sels) {
+			t.Errorf("got selections %v, want %v", got, sels)
+		}
+	}
+
+	// From the column 1 on the first line to the column 10,
+	// the end of the last line. The tabs span the columns 1-7
+	// and 2-7.
+	body.StartBlock(1, width)
+	body.MoveSel(17)
+	check([2]int64{1, 4}, [2]int64{6, 10}, [2]int64{11, 11}, [2]int64{13, 17})
+	if q0, q1 := body.Selected(); q0 != 13 || q1 != 17 {
+		t.Errorf("got primary selection %d,%d, want 13,17", q0, q1)
+	}
+	if got, want := body.selectedText(), "\tb1\nyzžw\n\nb\tc2"; got != want {
+		t.Errorf("got selected text %q, want %q", got, want)
+	}
+
+	// Upwards, on the first two lines.
+	body.MoveSel(9)
+	check([2]int64{1, 2}, [2]int64{6, 9})
+	body.StopSel()
+	body.MoveSel(4)
+	check([2]int64{1, 2}, [2]int64{6, 9})
+
+	// An empty block puts a cursor on each line.
+	body.StartBlock(1, width)
+	body.MoveSel(13)
+	check([2]int64{1, 1}, [2]int64{6, 6}, [2]int64{11, 11}, [2]int64{13, 13})
+	body.StopSel()
+	body.Insert("|")
+	if got, want := body.String(), "a|\tb1\nx|yzžw\n|\na|b\tc2\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestSetStatus(t *testing.T) {
+	text := newText(nil, &BasicBuffer{[]rune("Del ")})
+	text.setStatus("10 lines ")
+	text.Select(0, 0)
+	text.Insert("x")
+	text.Select(text.buf.End(), text.buf.End())
+	text.Insert("Get")
+	text.setStatus("20 lines ")
+	if got, want := text.String(), "xDel 20 lines Get"; got != want {
+		t.Errorf("got tag %q, want %q", got, want)
+	}
+	if q := text.buf.End(); text.q0 != q || text.q1 != q {
+		t.Errorf("got selection %d,%d, want %d,%d", text.q0, text.q1, q, q)
+	}
+
+	text.Select(1, 6)
+	text.DeleteSel()
+	text.setStatus("")
+	if got, want := text.String(), "xGet"; got != want {
+		t.Errorf("got tag %q, want %q", got, want)
+	}
+}
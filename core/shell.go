@@ -0,0 +1,297 @@
+package core
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Commands are parsed with a subset of the shell syntax: words can
+// be quoted using '' and "", characters can be escaped using \, $VAR
+// and ${VAR} are expanded, and words containing unquoted *, ? or [
+// are expanded to the matching file names. Commands are separated
+// by |. Anything else, like redirections, && or ;, is passed to
+// $SHELL -c as a whole.
+//
+// A command prefixed by <, > or | reads the selection of the window,
+// replaces it with its output, or both.
+
+var (
+	errEmptyCmd  = errors.New("empty command")
+	errNeedShell = errors.New("command requires a shell")
+)
+
+type pipeline struct {
+	pipeInput  bool
+	pipeOutput bool
+	*pipe
+}
+
+type pipe struct {
+	cmd  *command
+	prev *pipe
+}
+
+type command struct {
+	cmd  string
+	args []string
+
+	// patterns holds the glob patterns of args, or "" for
+	// arguments that aren't to be expanded.
+	patterns []string
+
+	name string // shown in the tag instead of cmd if set
+}
+
+// parse parses the command s. The variables are looked up
+// using getenv.
+func parse(s string, getenv func(string) string) (*pipeline, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, errEmptyCmd
+	}
+	p := &pipeline{}
+	switch r := s[0]; r {
+	case '<', '|', '>':
+		s = strings.TrimSpace(s[1:])
+		p.pipeInput = true
+		p.pipeOutput = true
+		if r == '<' {
+			p.pipeInput = false
+		} else if r == '>' {
+			p.pipeOutput = false
+		}
+	}
+
+	cmds, err := lex(s, getenv)
+	if err == errNeedShell {
+		name := s
+		if i := strings.IndexAny(s, " \t\n;&|<>()"); i > 0 {
+			name = s[:i]
+		}
+		p.pipe = &pipe{cmd: &command{
+			cmd:  shell(),
+			args: []string{"-c", s},
+			name: name,
+		}}
+		return p, nil
+	} else if err != nil {
+		return nil, err
+	}
+	for _, c := range cmds {
+		if len(c) == 0 {
+			return nil, errors.New("missing command")
+		}
+		cmd := &command{cmd: c[0].s}
+		for _, w := range c[1:] {
+			cmd.args = append(cmd.args, w.s)
+			cmd.patterns = append(cmd.patterns, w.pattern)
+		}
+		p.pipe = &pipe{cmd: cmd, prev: p.pipe}
+	}
+	return p, nil
+}
+
+// shell returns the shell used to run commands
+// that require one.
+func shell() string {
+	if sh := os.Getenv("SHELL"); sh != "" {
+		return sh
+	}
+	return "sh"
+}
+
+// A word is a parsed argument.
+type word struct {
+	s       string
+	pattern string // glob pattern, if s contains unquoted metacharacters
+}
+
+// lex splits s into commands separated by | and the commands into
+// words. It returns errNeedShell if s contains syntax that is only
+// understood by a shell.
+func lex(s string, getenv func(string) string) ([][]word, error) {
+	var (
+		cmds [][]word
+		args []word
+		lit  []byte // the word
+		pat  []byte // the word as a glob pattern
+		glob bool   // pat contains metacharacters
+		in   bool   // inside a word
+	)
+	quoted := func(b ...byte) {
+		for _, c := range b {
+			lit = append(lit, c)
+			if strings.IndexByte(`*?[\`, c) != -1 {
+				pat = append(pat, '\\')
+			}
+			pat = append(pat, c)
+		}
+		in = true
+	}
+	endWord := func() {
+		if in {
+			w := word{s: string(lit)}
+			if glob {
+				w.pattern = string(pat)
+			}
+			args = append(args, w)
+		}
+		lit, pat = lit[:0], pat[:0]
+		glob, in = false, false
+	}
+
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case ' ', '\t', '\n':
+			endWord()
+		case '|':
+			if i+1 < len(s) && s[i+1] == '|' {
+				return nil, errNeedShell
+			}
+			endWord()
+			cmds = append(cmds, args)
+			args = nil
+		case ';', '&', '<', '>', '(', ')', '`':
+			return nil, errNeedShell
+		case '\\':
+			if i+1 == len(s) {
+				return nil, errors.New("trailing backslash")
+			}
+			i++
+			quoted(s[i])
+		case '\'':
+			j := strings.IndexByte(s[i+1:], '\'')
+			if j == -1 {
+				return nil, errors.New("unterminated quoted string")
+			}
+			quoted([]byte(s[i+1 : i+1+j])...)
+			i += j + 1
+		case '"':
+			in = true
+			for i++; ; i++ {
+				if i == len(s) {
+					return nil, errors.New("unterminated quoted string")
+				}
+				c := s[i]
+				if c == '"' {
+					break
+				}
+				switch {
+				case c == '\\' && i+1 < len(s) && strings.IndexByte("\"\\$`", s[i+1]) != -1:
+					i++
+					quoted(s[i])
+				case c == '`':
+					return nil, errNeedShell
+				case c == '$':
+					val, n, err := expandVar(s[i:], getenv)
+					if err != nil {
+						return nil, err
+					}
+					quoted([]byte(val)...)
+					i += n - 1
+				default:
+					quoted(c)
+				}
+			}
+		case '$':
+			val, n, err := expandVar(s[i:], getenv)
+			if err != nil {
+				return nil, err
+			}
+			if val != "" {
+				// An empty unquoted variable doesn't
+				// form a word.
+				quoted([]byte(val)...)
+			}
+			i += n - 1
+		case '~':
+			if !in && (i+1 == len(s) || strings.IndexByte("/ \t\n|", s[i+1]) != -1) {
+				quoted([]byte(getenv("HOME"))...)
+				break
+			}
+			fallthrough
+		default:
+			if c == '*' || c == '?' || c == '[' {
+				glob = true
+			}
+			lit = append(lit, c)
+			pat = append(pat, c)
+			in = true
+		}
+	}
+	endWord()
+	return append(cmds, args), nil
+}
+
+// expandVar expands the variable at the beginning of s, which starts
+// with $. It returns the value and the number of bytes consumed.
+func expandVar(s string, getenv func(string) string) (val string, n int, err error) {
+	if len(s) == 1 {
+		return "$", 1, nil
+	}
+	switch c := s[1]; {
+	case c == '{':
+		i := strings.IndexByte(s, '}')
+		if i == -1 {
+			return "", 0, errors.New("missing }")
+		}
+		return getenv(s[2:i]), i + 1, nil
+	case c == '(':
+		return "", 0, errNeedShell
+	case c == '%':
+		// Acme's name of the file.
+		return getenv("%"), 2, nil
+	case isNameChar(c) && !('0' <= c && c <= '9'):
+		n = 2
+		for n < len(s) && isNameChar(s[n]) {
+			n++
+		}
+		return getenv(s[1:n]), n, nil
+	case c == '?' || c == '$' || c == '!' || c == '#' || c == '@' || c == '*' || '0' <= c && c <= '9':
+		// Special parameters only make sense in a shell.
+		return "", 0, errNeedShell
+	}
+	return "$", 1, nil
+}
+
+func isNameChar(c byte) bool {
+	return c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9'
+}
+
+// argv returns the arguments of the command with the glob patterns
+// expanded relative to the directory dir. Patterns that don't match
+// any file are left as they are, and arguments without a pattern are
+// taken literally.
+func (c *command) argv(dir string) []string {
+	var args []string
+	for i, a := range c.args {
+		var pat string
+		if i < len(c.patterns) {
+			pat = c.patterns[i]
+		}
+		if pat == "" {
+			args = append(args, a)
+			continue
+		}
+		abs := pat
+		if !filepath.IsAbs(pat) && dir != "" {
+			abs = filepath.Join(dir, pat)
+		}
+		matches, err := filepath.Glob(abs)
+		if err != nil || len(matches) == 0 {
+			args = append(args, a)
+			continue
+		}
+		for _, m := range matches {
+			if abs != pat {
+				if rel, err := filepath.Rel(dir, m); err == nil {
+					m = rel
+				}
+			}
+			args = append(args, m)
+		}
+	}
+	return args
+}
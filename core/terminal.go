@@ -0,0 +1,117 @@
+package core
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/mibk/syd/vt"
+)
+
+// A winTerm is a program running in a terminal emulator in a window
+// started by the Term command. While the program runs, the window
+// shows the screen of the terminal instead of the body; when it exits,
+// the last screen is put in the body.
+type winTerm struct {
+	*ptyCmd
+	win *Window
+	vt  *vt.Terminal
+}
+
+// startTerm starts command in a terminal emulator in a new window
+// in the directory dir. If command is empty, $SHELL is started.
+func (col *Column) startTerm(dir, command string, env []string) (*Window, error) {
+	const cols, rows = 80, 24
+	args := []string{shell()}
+	name := filepath.Base(args[0])
+	if command != "" {
+		args = append(args, "-c", command)
+		name = strings.Fields(command)[0]
+	}
+	p, err := startPty(dir, args, append(env, "TERM=xterm-256color"), cols, rows, false)
+	if err != nil {
+		return nil, err
+	}
+	win := col.NewWindow()
+	win.SetFilename(cmdWinName(dir, name, win.id))
+	tm := &winTerm{ptyCmd: p, win: win}
+	tm.vt = vt.New(cols, rows, replyWriter{p})
+	win.term = tm
+	win.OnClose(func(bool) { tm.close(win.term != nil) })
+	go tm.readOutput(win.stop, tm.output, tm.exited)
+	return win, nil
+}
+
+// replyWriter sends the replies of the terminal emulator
+// to the program.
+type replyWriter struct{ p *ptyCmd }
+
+func (w replyWriter) Write(b []byte) (int, error) {
+	w.p.send(append([]byte(nil), b...))
+	return len(b), nil
+}
+
+func (tm *winTerm) output(s string) {
+	if !tm.win.closed() {
+		tm.vt.Write([]byte(s))
+	}
+}
+
+// exited puts the last screen in the body and switches the window
+// back to showing it.
+func (tm *winTerm) exited(err error) {
+	if tm.win.closed() {
+		return
+	}
+	s := tm.vt.String()
+	if err != nil {
+		s += err.Error() + "\n"
+	}
+	body := tm.win.body
+	body.setText(s)
+	tm.win.buf.Commit()
+	tm.win.term = nil
+}
+
+// Terminal returns the screen of the program running in the window
+// of the text if t is its body, or nil.
+func (t *Text) Terminal() *vt.Terminal {
+	if tm := t.term(); tm != nil {
+		return tm.vt
+	}
+	return nil
+}
+
+// ResizeTerminal changes the size of the terminal the program in the
+// window of the text runs on.
+func (t *Text) ResizeTerminal(cols, rows int) {
+	tm := t.term()
+	if tm == nil {
+		return
+	}
+	if c, r := tm.vt.Size(); c == cols && r == rows {
+		return
+	}
+	tm.vt.Resize(cols, rows)
+	c, r := tm.vt.Size()
+	setPtySize(tm.master, c, r)
+}
+
+// SendTerminal sends b, typically an encoded key press, to the program
+// running in the window of the text.
+func (t *Text) SendTerminal(b []byte) {
+	if tm := t.term(); tm != nil && len(b) > 0 {
+		tm.send(b)
+	}
+}
+
+// term returns the program running in the window if t is its body.
+func (t *Text) term() *winTerm {
+	if t.ctx == nil {
+		return nil
+	}
+	win, ok := t.ctx.window()
+	if !ok || win.body != t {
+		return nil
+	}
+	return win.term
+}
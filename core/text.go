@@ -3,8 +3,12 @@ package core
 import (
 	"io"
 	"os"
+	"path/filepath"
+	"strings"
 	"unicode"
 	"unicode/utf8"
+
+	"github.com/atotto/clipboard"
 )
 
 type Text struct {
@@ -15,8 +19,15 @@ type Text struct {
 	q0, q1 int64
 	selEnd *int64
 
+	// the other selections than q0,q1, sorted
+	// and not overlapping
+	extra [][2]int64
+	block *block // block being selected
+
 	// position for ReadRune
 	pp int64
+
+	search *search // running incremental search
 }
 
 func newText(ctx cmdContext, buf Buffer) *Text {
@@ -35,6 +46,16 @@ func (t *Text) ReadRune() (r rune, size int, err error) {
 	return t.buf.ReadRuneAt(t.pp - 1)
 }
 
+// HexMode reports whether the text is the body of a window
+// that is to be displayed as hexadecimal bytes.
+func (t *Text) HexMode() bool {
+	if t.ctx == nil {
+		return false
+	}
+	win, ok := t.ctx.window()
+	return ok && win.hex && win.body == t
+}
+
 func (t *Text) Origin() int64 { return t.origin }
 
 func (t *Text) SetOrigin(org int64) { t.origin = org }
@@ -42,9 +63,13 @@ func (t *Text) SetOrigin(org int64) { t.origin = org }
 func (t *Text) Selected() (q0, q1 int64) { return t.q0, t.q1 }
 
 func (t *Text) SelectionToString(q0, q1 int64) string {
-	s := make([]rune, 0, q1-q0)
+	s := make([]byte, 0, q1-q0)
 	for p := q0; p < q1; p++ {
-		s = append(s, t.readRuneAt(p))
+		r := t.readRuneAt(p)
+		if r == EOF {
+			break
+		}
+		s = appendRune(s, r)
 	}
 	return string(s)
 }
@@ -54,20 +79,166 @@ func (t *Text) Select(q0, q1 int64) {
 		return
 	}
 	t.q0, t.q1 = q0, q1
+	t.extra = nil
+}
+
+// readOnly reports whether t is the body of a read-only window.
+func (t *Text) readOnly() bool {
+	if t.ctx == nil {
+		return false
+	}
+	win, ok := t.ctx.window()
+	return ok && win.readOnly && win.body == t
 }
 
 func (t *Text) Insert(s string) {
-	if t.q0 != t.q1 {
-		t.buf.Delete(t.q0, t.q1)
+	if t.readOnly() {
+		return
 	}
-	t.buf.Insert(t.q0, s)
-	q := t.q0 + int64(utf8.RuneCountInString(s))
-	t.q0, t.q1 = q, q
+	t.replaceSelections(func(int) string { return s })
 }
 
 func (t *Text) DeleteSel() {
-	t.buf.Delete(t.q0, t.q1)
-	t.q1 = t.q0
+	if t.readOnly() {
+		return
+	}
+	t.replaceSelections(func(int) string { return "" })
+}
+
+// Snarf copies the selected text to the clipboard. The texts
+// of multiple selections are separated by newlines.
+func (t *Text) Snarf() {
+	if err := t.snarf(); err != nil {
+		errorf(t.ctx, "snarf: %v\n", err)
+	}
+}
+
+// Cut copies the selected text to the clipboard and deletes it.
+func (t *Text) Cut() {
+	if err := t.snarf(); err != nil {
+		errorf(t.ctx, "cut: %v\n", err)
+		return
+	}
+	t.DeleteSel()
+}
+
+func (t *Text) snarf() error {
+	return clipboard.WriteAll(t.selectedText())
+}
+
+// Paste replaces the selected text with the content of the clipboard.
+// If there are multiple selections and the content has as many lines,
+// each selection is replaced by one of them.
+func (t *Text) Paste() {
+	s, err := clipboard.ReadAll()
+	if err != nil {
+		errorf(t.ctx, "paste: %v\n", err)
+		return
+	}
+	if t.readOnly() {
+		return
+	}
+	parts := strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+	if n := len(t.extra) + 1; n == 1 || len(parts) != n {
+		t.Insert(s)
+		return
+	}
+	t.replaceSelections(func(i int) string { return parts[i] })
+}
+
+// reader returns a reader of the range q0,q1 of the text
+// that can be used by other goroutines.
+func (t *Text) reader(q0, q1 int64) io.Reader {
+	if buf, ok := t.buf.(*UndoBuffer); ok {
+		return buf.SectionReader(q0, q1)
+	}
+	return strings.NewReader(t.SelectionToString(q0, q1))
+}
+
+// String returns the whole text.
+func (t *Text) String() string { return t.SelectionToString(0, t.buf.End()) }
+
+// stringWithoutStatus returns the whole text except for the status
+// set by setStatus.
+func (t *Text) stringWithoutStatus() string {
+	if b, ok := t.buf.(*statusBuffer); ok {
+		return t.SelectionToString(0, b.q0) + t.SelectionToString(b.q1, b.End())
+	}
+	return t.String()
+}
+
+// setText replaces the whole text with s and moves the cursor
+// to the end.
+func (t *Text) setText(s string) {
+	t.buf.Delete(0, t.buf.End())
+	t.buf.Insert(0, s)
+	q := t.buf.End()
+	t.q0, t.q1 = q, q
+	t.extra = nil
+	t.origin = 0
+}
+
+// A statusBuffer is a buffer holding the status set by setStatus
+// in the runes from q0 to q1. The range moves with the edits
+// of the text around it.
+type statusBuffer struct {
+	Buffer
+	q0, q1 int64
+}
+
+func (b *statusBuffer) Insert(q int64, s string) {
+	b.Buffer.Insert(q, s)
+	n := int64(utf8.RuneCountInString(s))
+	switch {
+	case q <= b.q0:
+		b.q0 += n
+		b.q1 += n
+	case q < b.q1:
+		b.q1 += n
+	}
+}
+
+func (b *statusBuffer) Delete(q0, q1 int64) {
+	b.Buffer.Delete(q0, q1)
+	b.q0 = deletedPos(b.q0, q0, q1)
+	b.q1 = deletedPos(b.q1, q0, q1)
+}
+
+// deletedPos returns the position q after the runes
+// from q0 to q1 have been deleted.
+func deletedPos(q, q0, q1 int64) int64 {
+	switch {
+	case q >= q1:
+		return q - (q1 - q0)
+	case q > q0:
+		return q0
+	}
+	return q
+}
+
+// setStatus replaces the status of the text with s. The status
+// is initially appended to the text. Selections within the old
+// status are moved in front of it.
+func (t *Text) setStatus(s string) {
+	b, ok := t.buf.(*statusBuffer)
+	if !ok {
+		end := t.buf.End()
+		b = &statusBuffer{t.buf, end, end}
+		t.buf = b
+	}
+	q, end := b.q0, b.q1
+	b.Buffer.Delete(q, end)
+	b.Buffer.Insert(q, s)
+	b.q1 = q + int64(utf8.RuneCountInString(s))
+	for _, p := range []*int64{&t.q0, &t.q1} {
+		switch {
+		case *p > end:
+			*p += b.q1 - end
+		case *p > q:
+			*p = q
+		}
+	}
+	t.extra = nil
 }
 
 func (t *Text) PrevNewLine(p int64, n int) int64 {
@@ -102,10 +273,16 @@ func (t *Text) readRuneAt(off int64) rune {
 
 func (t *Text) StartSel(q int64) {
 	t.q0, t.q1 = q, q
+	t.extra = nil
+	t.block = nil
 	t.selEnd = &t.q1
 }
 
 func (t *Text) MoveSel(q int64) {
+	if t.block != nil {
+		t.selectBlock(q)
+		return
+	}
 	if t.selEnd == nil {
 		return
 	}
@@ -120,39 +297,49 @@ func (t *Text) MoveSel(q int64) {
 	}
 }
 
-func (t *Text) StopSel() { t.selEnd = nil }
+func (t *Text) StopSel() {
+	t.selEnd = nil
+	t.block = nil
+}
 
 func (t *Text) SelectUnderCursor(q int64) {
 	t.Select(t.dblclick(q))
 	t.selEnd = nil
 }
 
-func (t *Text) ExecuteUnderCursor(q int64) {
+func (t *Text) ExecuteUnderCursor(q int64) { t.ExecuteWithArg(q, "") }
+
+// ExecuteWithArg executes the command under the cursor, as
+// ExecuteUnderCursor does, with arg appended to it as its argument.
+func (t *Text) ExecuteWithArg(q int64, arg string) {
 	cmd := t.selected(q)
 	if cmd == "" {
 		cmd = t.selectPath(q)
 	}
+	t.executeArg(cmd, arg)
+}
+
+// ExecuteRange executes the text in the range q0,q1 as a command
+// with arg appended to it as its argument.
+func (t *Text) ExecuteRange(q0, q1 int64, arg string) {
+	t.executeArg(t.SelectionToString(q0, q1), arg)
+}
+
+func (t *Text) executeArg(cmd, arg string) {
+	if cmd != "" && arg != "" {
+		cmd += " " + arg
+	}
 	execute(t.ctx, cmd)
 }
 
 func (t *Text) Plumb(q int64) {
 	query := t.selected(q)
-
-	// TODO: Don't require being in the column context. Just
-	// open the file in the most recent column (using similar
-	// heuristic as in Acme).
-	if col, ok := t.ctx.column(); ok {
-		path := query
-		if path == "" {
-			path = t.selectPath(q)
-		}
-		if _, ok := col.ed.wins[path]; ok {
-			return
-		}
-		if _, err := os.Stat(path); err == nil {
-			col.NewWindowFile(path)
-			return
-		}
+	path := query
+	if path == "" {
+		path = t.selectPath(q)
+	}
+	if t.openPath(path) {
+		return
 	}
 
 	if win, ok := t.ctx.window(); ok {
@@ -165,6 +352,44 @@ func (t *Text) Plumb(q int64) {
 	}
 }
 
+// PlumbRange opens the file named by the text in the range q0,q1,
+// or looks for the next occurrence of the text if it doesn't name
+// a file.
+func (t *Text) PlumbRange(q0, q1 int64) {
+	s := t.SelectionToString(q0, q1)
+	if t.openPath(s) {
+		return
+	}
+	if win, ok := t.ctx.window(); ok {
+		t.Select(q0, q1)
+		win.findNextExactMatch(s)
+	}
+}
+
+// openPath opens the file path, which may be followed by an address
+// as in file:line:col:, if it exists. A relative path is relative to
+// the directory of the window of the text. It reports whether path
+// names a file.
+func (t *Text) openPath(path string) bool {
+	name, addr := SplitLineSuffix(strings.TrimSuffix(path, ":"))
+	if name == "" {
+		return false
+	}
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(ctxDir(t.ctx), name)
+	}
+	ed := t.ctx.editor()
+	if ed.lookupFile(name) == nil {
+		if _, err := os.Stat(name); err != nil {
+			return false
+		}
+	}
+	if _, err := ed.Open(name, addr); err != nil {
+		errorf(t.ctx, "%v\n", err)
+	}
+	return true
+}
+
 // selected returns the selection if q is between
 // t.q0 and t.q1, otherwise it returns an empty
 // string.
@@ -206,10 +431,22 @@ func isAlphaNumeric(r rune) bool {
 	return unicode.IsLetter(r) || unicode.IsDigit(r)
 }
 
-func isPath(r rune) bool { return !unicode.IsSpace(r) && r != EOF && r != 0 }
+func isPath(r rune) bool {
+	if _, ok := RawByte(r); ok {
+		return false
+	}
+	return !unicode.IsSpace(r) && r != EOF && r != 0
+}
 
 func (t *Text) InsertNewLine() {
 	q0, _ := t.Selected()
+	if sh := t.shell(); sh != nil && q0 >= sh.outputPoint() {
+		// Send the typed line to the command
+		// instead of indenting it.
+		t.Insert("\n")
+		sh.sendLine()
+		return
+	}
 	p := t.PrevNewLine(q0, 1)
 
 	var indent []rune
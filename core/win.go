@@ -0,0 +1,155 @@
+package core
+
+import (
+	"fmt"
+	"path/filepath"
+	"unicode/utf8"
+)
+
+// A winShell is a command, typically a shell, running in a window
+// started by the Win command. Its output is inserted in the body at
+// the output point; a line typed after the output point is sent to
+// the command when Enter is pressed.
+type winShell struct {
+	*ptyCmd
+	win *Window
+
+	outq int64 // output point
+
+	history []string
+	histPos int // index of the recalled history entry
+}
+
+// startWin starts command in a new window in the directory dir. If
+// command is empty, $SHELL is started.
+func (col *Column) startWin(dir, command string, env []string) (*Window, error) {
+	args := []string{shell()}
+	if command != "" {
+		args = append(args, "-c", command)
+	}
+	p, err := startPty(dir, args, append(env, "TERM=dumb"), 80, 24, true)
+	if err != nil {
+		return nil, err
+	}
+	win := col.NewWindow()
+	win.SetFilename(cmdWinName(dir, args[0], win.id))
+	sh := &winShell{ptyCmd: p, win: win}
+	win.shell = sh
+	win.OnClose(func(bool) { sh.close(win.shell != nil) })
+	go sh.readOutput(win.stop, sh.insertOutput, sh.exited)
+	return win, nil
+}
+
+// cmdWinName returns the name of the window with the id running
+// the command name in the directory dir, e.g. /src/-bash.3. The id
+// tells apart the windows running the same command in the same
+// directory.
+func cmdWinName(dir, name string, id int) string {
+	return filepath.Join(dir, fmt.Sprintf("-%s.%d", filepath.Base(name), id))
+}
+
+// insertOutput inserts s at the output point. The selections after
+// the output point move along.
+func (sh *winShell) insertOutput(s string) {
+	win := sh.win
+	if win.closed() {
+		return
+	}
+	q := sh.outputPoint()
+	win.buf.Insert(q, s)
+	n := int64(utf8.RuneCountInString(s))
+	win.body.moveSelections(q, n)
+	sh.outq = q + n
+	win.buf.Commit()
+}
+
+// exited reports the exit status of the command in the body.
+func (sh *winShell) exited(err error) {
+	if sh.win.closed() {
+		return
+	}
+	if err != nil {
+		sh.insertOutput("\n" + err.Error() + "\n")
+	}
+	sh.win.shell = nil
+}
+
+// outputPoint returns the output point, which might have been moved
+// beyond the end of the body by Undo.
+func (sh *winShell) outputPoint() int64 {
+	if end := sh.win.buf.End(); sh.outq > end {
+		sh.outq = end
+	}
+	return sh.outq
+}
+
+// edited moves the output point after the range q0,q1 of the body
+// has been replaced by n runes.
+func (sh *winShell) edited(q0, q1, n int64) {
+	switch {
+	case sh.outq <= q0:
+		// Text typed at the output point is input.
+	case sh.outq >= q1:
+		sh.outq += n - (q1 - q0)
+	default:
+		sh.outq = q0
+	}
+}
+
+// sendLine sends the text typed after the output point, which ends
+// with a newline, to the command.
+func (sh *winShell) sendLine() {
+	body := sh.win.body
+	q := sh.outputPoint()
+	line := body.SelectionToString(q, body.q0)
+	sh.outq = body.q0
+	sh.win.buf.Commit()
+	if l := line[:len(line)-1]; l != "" {
+		sh.history = append(sh.history, l)
+	}
+	sh.histPos = len(sh.history)
+	sh.send([]byte(line))
+}
+
+// recall replaces the text typed after the output point with an entry
+// of the history: the previous one if dir is negative, the next one
+// otherwise.
+func (sh *winShell) recall(dir int) {
+	pos := sh.histPos + dir
+	if pos < 0 || pos > len(sh.history) {
+		return
+	}
+	sh.histPos = pos
+	s := ""
+	if pos < len(sh.history) {
+		s = sh.history[pos]
+	}
+	body := sh.win.body
+	body.Select(sh.outputPoint(), sh.win.buf.End())
+	body.Insert(s)
+}
+
+// interrupt sends the interrupt character to the terminal.
+func (sh *winShell) interrupt() {
+	sh.send([]byte{0x03})
+}
+
+// History recalls the previous (dir < 0) or next (dir > 0) line sent
+// to the command running in the window of the text.
+func (t *Text) History(dir int) {
+	if sh := t.shell(); sh != nil {
+		sh.recall(dir)
+	}
+}
+
+// shell returns the command running in the window if t is its body.
+func (t *Text) shell() *winShell {
+	if t.ctx == nil {
+		return nil
+	}
+	win, ok := t.ctx.window()
+	if !ok || win.body != t {
+		return nil
+	}
+	return win.shell
+}
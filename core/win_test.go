@@ -0,0 +1,125 @@
+package core
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWin(t *testing.T) {
+	t.Setenv("SHELL", "/bin/sh")
+	t.Setenv("PS1", "$ ")
+	master, slave, err := openPty()
+	if err != nil {
+		t.Skip(err)
+	}
+	master.Close()
+	slave.Close()
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ed := newTestEditor()
+	win := ed.recentCol().NewWindow()
+	win.SetFilename(filepath.Join(dir, "main.go"))
+
+	execute(win, "Win")
+	sh := ed.wins[cmdWinName(dir, "sh", win.id+1)]
+	if sh == nil {
+		t.Fatalf("no shell window: %s", ed.errorWindow(dir).body.String())
+	}
+
+	// Another shell in the same directory is a different window.
+	execute(win, "Win")
+	sh2 := ed.wins[cmdWinName(dir, "sh", win.id+2)]
+	if sh2 == nil {
+		t.Fatalf("no second shell window: %s", ed.errorWindow(dir).body.String())
+	}
+	sh2.Close()
+	if ed.wins[sh.filename] != sh {
+		t.Fatal("closing the second shell window removed the first one")
+	}
+
+	body := sh.body
+	typeLine := func(s string) {
+		end := sh.buf.End()
+		body.Select(end, end)
+		body.Insert(s)
+		body.InsertNewLine()
+	}
+	typeLine("echo hi; pwd")
+	runEvents(t, func() bool { return strings.Contains(body.String(), "hi\n"+dir+"\n") })
+
+	end := sh.buf.End()
+	body.History(-1)
+	if got, want := body.SelectionToString(end, sh.buf.End()), "echo hi; pwd"; got != want {
+		t.Errorf("History(-1): got %q, want %q", got, want)
+	}
+	body.History(1)
+	if got := sh.buf.End(); got != end {
+		t.Errorf("History(1) didn't restore the empty line")
+	}
+
+	typeLine("exit 3")
+	runEvents(t, func() bool { return sh.shell == nil })
+	if s := body.String(); !strings.HasSuffix(s, "exit status 3\n") {
+		t.Errorf("exit status not reported: %q", s)
+	}
+	sh.Close()
+}
+
+func TestTerm(t *testing.T) {
+	t.Setenv("SHELL", "/bin/sh")
+	master, slave, err := openPty()
+	if err != nil {
+		t.Skip(err)
+	}
+	master.Close()
+	slave.Close()
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ed := newTestEditor()
+	win := ed.recentCol().NewWindow()
+	win.SetFilename(filepath.Join(dir, "main.go"))
+
+	execute(win, `Term printf 'a\033[2Cb\n%s\n' $TERM; read x; echo "<$x>"`)
+	tm := ed.wins[cmdWinName(dir, "printf", win.id+1)]
+	if tm == nil {
+		t.Fatalf("no terminal window: %s", ed.errorWindow(dir).body.String())
+	}
+	body := tm.body
+	runEvents(t, func() bool {
+		return strings.Contains(body.Terminal().String(), "xterm-256color\n")
+	})
+	if got, want := body.Terminal().String(), "a  b\nxterm-256color\n"; got != want {
+		t.Errorf("got screen %q, want %q", got, want)
+	}
+	body.ResizeTerminal(40, 10)
+	if c, r := body.Terminal().Size(); c != 40 || r != 10 {
+		t.Errorf("got size %dx%d, want 40x10", c, r)
+	}
+	body.SendTerminal([]byte("hi\r"))
+	runEvents(t, func() bool { return tm.term == nil })
+	if got, want := body.String(), "a  b\nxterm-256color\nhi\n<hi>\n"; got != want {
+		t.Errorf("got body %q, want %q", got, want)
+	}
+	tm.Close()
+}
+
+func TestPtySend(t *testing.T) {
+	p, err := startPty("", []string{"sleep", "10"}, nil, 80, 24, true)
+	if err != nil {
+		t.Skip(err)
+	}
+	// The command doesn't read its input,
+	// which mustn't block the sender.
+	b := make([]byte, 64<<10)
+	for i := 0; i < 64; i++ {
+		p.send(b)
+	}
+	p.close(true)
+	p.send([]byte("after close\n"))
+	p.close(false)
+}
@@ -2,11 +2,14 @@ package core
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"regexp"
+	"sync"
+	"time"
 	"unicode/utf8"
 
 	"github.com/mibk/syd/ui"
@@ -14,18 +17,43 @@ import (
 
 const EOF = utf8.MaxRune + 1
 
+// Files of at least largeFileSize bytes are opened in the large file
+// mode: their encoding isn't detected and their lines are counted in
+// the background.
+const largeFileSize = 64 << 20
+
 type Window struct {
+	id       int // unique within the editor
 	col      *Column
 	filename string
 	win      ui.Updater
 	con      Content
 
+	enc      Encoding // encoding used when saving the file
+	savedEnc Encoding // encoding of the file on disk
+
 	buf  *UndoBuffer
 	tag  *Text
 	body *Text
 
-	// used by Read and flush methods
-	insertbuf bytes.Buffer
+	// start of the text inserted by Write since
+	// the last flush; valid if writing is true
+	written int64
+	writing bool
+
+	hex      bool // display the body as hexadecimal bytes
+	readOnly bool
+	saved    bool // whether the file has been written since opened
+
+	shell *winShell // command started by Win, if running
+	term  *winTerm  // program started by Term, if running
+
+	// stop is closed when the window is closed to stop
+	// the background goroutines tracked by bg.
+	stop chan struct{}
+	bg   sync.WaitGroup
+
+	onClose []func(dirty bool)
 
 	y float64
 
@@ -35,13 +63,57 @@ type Window struct {
 func (win *Window) SetFilename(filename string) {
 	win.filename = filename
 	win.tag.buf.Insert(0, filename)
-	win.col.ed.wins[filename] = win
+	// A file may be open in several windows, as after Load;
+	// the first one is looked up by its name.
+	if _, ok := win.col.ed.wins[filename]; !ok {
+		win.col.ed.wins[filename] = win
+	}
+
+	// Only the files and the unnamed windows are journaled,
+	// not the windows like +Errors.
+	j := win.buf.journal
+	switch {
+	case !isFileName(filename):
+		if j != nil {
+			j.remove()
+		}
+		win.buf.journal, win.buf.startJournal = nil, nil
+	case j != nil:
+		if err := j.rename(filename); err != nil {
+			errorf(win, "journal of %s: %v\n", filename, err)
+			j.remove()
+			win.buf.journal = nil
+		}
+	default:
+		win.buf.startJournal = win.startJournal
+	}
+}
+
+// startJournal creates the journal of the window, whose current
+// content is the base of the journal, or returns nil if the window
+// can't be journaled.
+func (win *Window) startJournal() *journal {
+	if win.shell != nil || win.term != nil {
+		return nil
+	}
+	base, err := ioutil.ReadAll(io.NewSectionReader(win.buf, 0, win.buf.Size()))
+	if err != nil {
+		return nil
+	}
+	j, err := newJournal(win.filename, win.enc, base)
+	if err != nil {
+		return nil
+	}
+	return j
 }
 
 func (win *Window) Dirty() bool {
-	return win.buf.Dirty()
+	return win.buf.Dirty() || win.enc != win.savedEnc
 }
 
+// SetEncoding changes the encoding the file will be saved in.
+func (win *Window) SetEncoding(enc Encoding) { win.enc = enc }
+
 func (win *Window) Y() float64 { return win.y }
 
 func (win *Window) bottom() float64 {
@@ -62,56 +134,168 @@ func (win *Window) Tag() *Text { return win.tag }
 
 func (win *Window) Body() *Text { return win.body }
 
+// OnClose registers fn to be called when the window is closed.
+// Dirty reports whether the window has unsaved changes.
+func (win *Window) OnClose(fn func(dirty bool)) { win.onClose = append(win.onClose, fn) }
+
+// Saved reports whether the file has been written since
+// the window was opened.
+func (win *Window) Saved() bool { return win.saved }
+
 func (win *Window) Close() error {
+	if win.closed() {
+		// Closed again, e.g. by an OnClose function.
+		return nil
+	}
+	close(win.stop)
+	dirty := win.Dirty()
+	for _, fn := range win.onClose {
+		fn(dirty)
+	}
+	win.bg.Wait()
+	if j := win.buf.journal; j != nil {
+		j.remove()
+	}
+	ed := win.col.ed
 	win.win.Update(ui.Delete)
 	win.col.removeWindow(win)
-	if ed := win.col.ed; ed.errWin == win {
-		ed.errWin = nil
+	if win.filename != "" && ed.wins[win.filename] == win {
+		delete(ed.wins, win.filename)
+		for _, w := range ed.windows() {
+			if w.filename == win.filename {
+				ed.wins[w.filename] = w
+				break
+			}
+		}
+	}
+
+	// Closing the content unmaps the file, so the commands
+	// reading the body must exit first.
+	var readers []*proc
+	for _, p := range ed.procs {
+		if p.input == win {
+			p.kill()
+			readers = append(readers, p)
+		}
 	}
-	if win.filename != "" {
-		delete(win.col.ed.wins, win.filename)
+	if len(readers) > 0 {
+		go func() {
+			for _, p := range readers {
+				<-p.done
+			}
+			win.con.Close()
+		}()
+		return nil
 	}
 	return win.con.Close()
 }
 
+// post sends fn to be called by the UI goroutine. It reports false
+// if the window has been closed in the meantime.
+func (win *Window) post(fn func()) bool {
+	select {
+	case ui.Events <- fn:
+		return true
+	case <-win.stop:
+		return false
+	}
+}
+
+// countLines counts the lines of the window's content in the
+// background and reports the progress in the tag.
+func (win *Window) countLines() {
+	data := win.con.Bytes()
+	win.bg.Add(1)
+	go func() {
+		defer win.bg.Done()
+		const chunk = 4 << 20
+		var lines int
+		var last time.Time
+		for i := 0; i < len(data); i += chunk {
+			j := i + chunk
+			if j > len(data) {
+				j = len(data)
+			}
+			lines += bytes.Count(data[i:j], []byte("\n"))
+			var status string
+			switch {
+			case j == len(data):
+				status = fmt.Sprintf("%d lines ", lines)
+			case time.Since(last) > 100*time.Millisecond:
+				status = fmt.Sprintf("%d lines… %d%% ", lines, 100*int64(j)/int64(len(data)))
+				last = time.Now()
+			default:
+				continue
+			}
+			if !win.post(func() { win.tag.setStatus(status) }) {
+				return
+			}
+		}
+	}()
+}
+
+// Write inserts b in place of the selection in the body.
 func (win *Window) Write(b []byte) (n int, err error) {
-	return win.insertbuf.Write(b)
+	if !win.writing {
+		win.written, win.writing = win.body.q0, true
+	}
+	win.body.Insert(string(b))
+	return len(b), nil
 }
 
+// flush selects the text inserted by Write since the last flush
+// and commits it as a single change.
 func (win *Window) flush() {
-	s := win.insertbuf.String()
-	win.insertbuf.Reset()
-	q := win.body.q0
-	win.body.Insert(s)
-	win.body.Select(q, q+int64(utf8.RuneCountInString(s)))
-
-	// TODO: Come up with a better solution?
+	if win.writing {
+		win.body.Select(win.written, win.body.q1)
+		win.writing = false
+	}
 	win.buf.Commit()
 }
 
-func (win *Window) saveFile() {
+func (win *Window) saveFile() error {
 	if win.filename == "" {
 		win.readFilename()
 	}
 
 	dst, err := filepath.Abs(win.filename)
 	if err != nil {
-		panic(err)
+		return err
 	}
+	var r io.Reader = io.NewSectionReader(win.buf, 0, win.buf.Size())
+	if win.enc != defaultEncoding {
+		text, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		b, err := win.enc.Encode(text)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	}
+
 	dir, file := filepath.Split(dst)
 	f, err := ioutil.TempFile(dir, ".~"+file)
 	if err != nil {
-		panic(err)
+		return err
 	}
-	r := io.NewSectionReader(win.buf, 0, win.buf.Size())
 	if _, err := io.Copy(f, r); err != nil {
-		panic(err)
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
 	}
-	f.Close()
-
 	if err := os.Rename(f.Name(), dst); err != nil {
-		panic(err)
+		return err
 	}
+	win.buf.Clean()
+	win.savedEnc = win.enc
+	win.saved = true
+	return nil
 }
 
 func (win *Window) readFilename() {
@@ -133,17 +317,38 @@ func (win *Window) readFilename() {
 
 func (win *Window) findNextExactMatch(s string) {
 	rx := regexp.MustCompile(regexp.QuoteMeta(s))
+	if q0, q1, ok := win.body.findForward(rx, win.body.q1, true); ok {
+		win.body.Select(q0, q1)
+	}
+}
 
-	body := win.body
-	buf := win.buf
-	for _, q := range []int64{body.q1, 0} {
-		r, off := buf.RuneReaderFrom(q)
-		if loc := rx.FindReaderIndex(r); loc != nil {
-			q0, q1 := buf.FindRange(off+int64(loc[0]), int64(loc[1]-loc[0]))
-			body.Select(q0, q1)
-			return
-		}
+// SelectAddr selects the range denoted by the address addr
+// in the body and scrolls it into view. See Text.addr for
+// the syntax of addresses.
+func (win *Window) SelectAddr(addr string) error {
+	q0, q1, err := win.body.addr(addr)
+	if err != nil {
+		return err
+	}
+	win.body.Select(q0, q1)
+	win.body.SetOrigin(win.body.PrevNewLine(q0, 3))
+	return nil
+}
+
+// SetReadOnly sets whether the body of the window can be modified.
+func (win *Window) SetReadOnly(readOnly bool) { win.readOnly = readOnly }
+
+// dir returns the absolute directory of the window's file.
+func (win *Window) dir() string {
+	if win.filename == "" {
+		wd, _ := os.Getwd()
+		return wd
+	}
+	abs, err := filepath.Abs(win.filename)
+	if err != nil {
+		return ""
 	}
+	return filepath.Dir(abs)
 }
 
 func (win *Window) editor() (ed *Editor)           { return win.col.ed }
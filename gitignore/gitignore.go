@@ -0,0 +1,130 @@
+// Package gitignore matches paths against the patterns of .gitignore
+// files as described in gitignore(5).
+//
+// Paths are slash-separated and relative to the root of the tree.
+// As in Git, a path within an ignored directory can't be re-included;
+// the tree is expected to be walked without descending into ignored
+// directories.
+package gitignore
+
+import (
+	"bufio"
+	"io"
+	"path"
+	"strings"
+)
+
+// A Matcher holds the patterns of the .gitignore files of a tree.
+type Matcher struct {
+	patterns []pattern
+}
+
+type pattern struct {
+	dir      string   // directory of the .gitignore file, "" for the root
+	segs     []string // slash-separated parts of the pattern
+	negate   bool     // the pattern starts with !
+	dirOnly  bool     // the pattern ends with /
+	anchored bool     // the pattern contains a slash
+}
+
+// Add adds the patterns read from r, which is the .gitignore file
+// in the directory dir.
+func (m *Matcher) Add(dir string, r io.Reader) error {
+	dir = strings.Trim(dir, "/")
+	if dir == "." {
+		dir = ""
+	}
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		if p, ok := parsePattern(s.Text()); ok {
+			p.dir = dir
+			m.patterns = append(m.patterns, p)
+		}
+	}
+	return s.Err()
+}
+
+func parsePattern(line string) (p pattern, ok bool) {
+	line = strings.TrimSuffix(line, "\r")
+	if !strings.HasSuffix(line, `\ `) {
+		line = strings.TrimRight(line, " ")
+	}
+	if line == "" || line[0] == '#' {
+		return p, false
+	}
+	if line[0] == '!' {
+		p.negate = true
+		line = line[1:]
+	} else if line[0] == '\\' && len(line) > 1 && (line[1] == '!' || line[1] == '#') {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if strings.Contains(line, "/") {
+		p.anchored = true
+		line = strings.TrimLeft(line, "/")
+	}
+	if line == "" {
+		return p, false
+	}
+	p.segs = strings.Split(line, "/")
+	return p, true
+}
+
+// Match reports whether the path name, which is a directory if isDir
+// is true, is ignored.
+func (m *Matcher) Match(name string, isDir bool) bool {
+	ignored := false
+	for _, p := range m.patterns {
+		if p.match(name, isDir) {
+			ignored = !p.negate
+		}
+	}
+	return ignored
+}
+
+func (p *pattern) match(name string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+	if p.dir != "" {
+		if !strings.HasPrefix(name, p.dir+"/") {
+			return false
+		}
+		name = name[len(p.dir)+1:]
+	}
+	if !p.anchored {
+		ok, _ := path.Match(p.segs[0], path.Base(name))
+		return ok
+	}
+	return matchSegs(p.segs, strings.Split(name, "/"))
+}
+
+// matchSegs matches the parts of a path against the parts of
+// a pattern, where ** matches any number of directories.
+func matchSegs(pat, name []string) bool {
+	for len(pat) > 0 {
+		if pat[0] == "**" {
+			if len(pat) == 1 {
+				// A trailing /** matches everything inside.
+				return len(name) > 0
+			}
+			for i := 0; i <= len(name); i++ {
+				if matchSegs(pat[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pat[0], name[0]); !ok {
+			return false
+		}
+		pat, name = pat[1:], name[1:]
+	}
+	return len(name) == 0
+}
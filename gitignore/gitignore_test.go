@@ -0,0 +1,61 @@
+package gitignore
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	var m Matcher
+	m.Add("", strings.NewReader(`# build output
+*.o
+/bin
+build/
+!keep.o
+docs/*.html
+**/testdata/**
+a/**/z
+\#hash
+trailing
+`))
+	m.Add("sub", strings.NewReader("local\n/rooted\n"))
+
+	tests := []struct {
+		name  string
+		isDir bool
+		want  bool
+	}{
+		{"main.o", false, true},
+		{"src/main.o", false, true},
+		{"keep.o", false, false},
+		{"src/keep.o", false, false},
+		{"main.go", false, false},
+		{"bin", true, true},
+		{"bin", false, true},
+		{"src/bin", true, false},
+		{"build", true, true},
+		{"src/build", true, true},
+		{"build", false, false},
+		{"docs/index.html", false, true},
+		{"docs/api/index.html", false, false},
+		{"x/testdata/f", false, true},
+		{"testdata/f", false, true},
+		{"testdata", true, false},
+		{"a/z", false, true},
+		{"a/b/c/z", false, true},
+		{"b/a/z", false, false},
+		{"#hash", false, true},
+		{"trailing", false, true},
+		{"sub/local", false, true},
+		{"sub/x/local", false, true},
+		{"local", false, false},
+		{"sub/rooted", false, true},
+		{"sub/x/rooted", false, false},
+		{"other/rooted", false, false},
+	}
+	for _, tt := range tests {
+		if got := m.Match(tt.name, tt.isDir); got != tt.want {
+			t.Errorf("Match(%q, %v) = %v, want %v", tt.name, tt.isDir, got, tt.want)
+		}
+	}
+}
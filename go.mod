@@ -7,8 +7,8 @@ require (
 	github.com/edsrzf/mmap-go v1.0.0
 	github.com/gdamore/tcell v1.3.0
 	github.com/lucasb-eyer/go-colorful v1.0.3 // indirect
-	github.com/mattn/go-runewidth v0.0.9 // indirect
+	github.com/mattn/go-runewidth v0.0.9
 	golang.org/x/mobile v0.0.0-20200801112145-973feb4309de
-	golang.org/x/sys v0.0.0-20200802091954-4b90ce9b60b3 // indirect
+	golang.org/x/sys v0.0.0-20200802091954-4b90ce9b60b3
 	golang.org/x/text v0.3.3 // indirect
 )
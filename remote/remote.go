@@ -0,0 +1,170 @@
+// Package remote lets a syd process open files in an already
+// running syd. The running syd listens on a per-user Unix socket;
+// a client sends a single request and receives a single response,
+// which is postponed until the files are closed if the client
+// wants to wait for them.
+package remote
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+)
+
+// A Request asks the running syd to open files.
+type Request struct {
+	Files    []File
+	ReadOnly bool `json:",omitempty"`
+	Wait     bool `json:",omitempty"` // respond once all the files are closed
+
+	// Edit makes waiting fail if any of the files is closed
+	// without being saved.
+	Edit bool `json:",omitempty"`
+}
+
+// A File is a file to be opened.
+type File struct {
+	Name string // absolute path of the file
+	Addr string `json:",omitempty"` // address to select
+
+	// Data holds the content of a window that isn't backed by
+	// a file. Names of such windows start with '+'.
+	Data []byte `json:",omitempty"`
+}
+
+type response struct {
+	Error string `json:",omitempty"`
+}
+
+// SocketPath returns the path of the socket of the current user.
+// It is $SYDSOCK if set.
+func SocketPath() string {
+	if p := os.Getenv("SYDSOCK"); p != "" {
+		return p
+	}
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "syd.sock")
+	}
+	return filepath.Join(fallbackDir(), "syd.sock")
+}
+
+// fallbackDir returns the directory holding the socket
+// if $XDG_RUNTIME_DIR isn't set.
+func fallbackDir() string {
+	return filepath.Join(os.TempDir(), fmt.Sprintf("syd-%d", os.Getuid()))
+}
+
+// checkDir returns an error unless dir is a directory accessible
+// only by the current user. The fallback directory is in a world
+// writable directory, so it might have been created by another
+// user to intercept the requests.
+func checkDir(dir string) error {
+	fi, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	if uid, ok := fileOwner(fi); ok && uid != os.Getuid() {
+		return fmt.Errorf("%s is not owned by the current user", dir)
+	}
+	if fi.Mode().Perm() != 0700 {
+		return fmt.Errorf("%s has mode %v, want %v", dir, fi.Mode().Perm(), os.FileMode(0700))
+	}
+	return nil
+}
+
+// Dial connects to the running syd listening on the socket path.
+func Dial(path string) (net.Conn, error) {
+	if dir := filepath.Dir(path); dir == fallbackDir() {
+		if err := checkDir(dir); err != nil {
+			return nil, err
+		}
+	}
+	return net.Dial("unix", path)
+}
+
+// Send sends req over conn and waits for the response.
+// It closes conn.
+func Send(conn net.Conn, req *Request) error {
+	defer conn.Close()
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return err
+	}
+	var resp response
+	if err := json.NewDecoder(conn).Decode(&resp); err == io.EOF {
+		return errors.New("syd exited")
+	} else if err != nil {
+		return err
+	}
+	if resp.Error != "" {
+		return errors.New(resp.Error)
+	}
+	return nil
+}
+
+// Listen listens on the socket path. A socket left by a syd that
+// is no longer running is replaced.
+func Listen(path string) (net.Listener, error) {
+	if dir := filepath.Dir(path); dir == fallbackDir() {
+		if err := os.Mkdir(dir, 0700); err != nil && !os.IsExist(err) {
+			return nil, err
+		}
+		if err := checkDir(dir); err != nil {
+			return nil, err
+		}
+	} else if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	l, err := net.Listen("unix", path)
+	if err == nil {
+		return l, nil
+	}
+	if conn, derr := Dial(path); derr == nil {
+		conn.Close()
+		return nil, fmt.Errorf("another syd is listening on %s", path)
+	}
+	if os.Remove(path) != nil {
+		return nil, err
+	}
+	return net.Listen("unix", path)
+}
+
+// A Handler handles a request. It must send exactly one value to
+// done: nil once the request has been fulfilled, or an error.
+type Handler func(req *Request, done chan<- error)
+
+// Serve accepts connections on l and calls h for every request.
+// It returns when l is closed.
+func Serve(l net.Listener, h Handler) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			return err
+		}
+		go serveConn(conn, h)
+	}
+}
+
+func serveConn(conn net.Conn, h Handler) {
+	defer conn.Close()
+	var req Request
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		return
+	}
+	done := make(chan error, 1)
+	h(&req, done)
+	var resp response
+	if err := <-done; err != nil {
+		resp.Error = err.Error()
+	}
+	json.NewEncoder(conn).Encode(&resp)
+}
@@ -0,0 +1,107 @@
+package remote
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRequest(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "syd.sock")
+	l, err := Listen(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	reqs := make(chan *Request, 1)
+	release := make(chan error)
+	go Serve(l, func(req *Request, done chan<- error) {
+		reqs <- req
+		go func() { done <- <-release }()
+	})
+
+	want := &Request{
+		Files: []File{
+			{Name: "/tmp/a.go", Addr: "12"},
+			{Name: "+stdin", Data: []byte("hello\n")},
+		},
+		Wait: true,
+	}
+	for _, tt := range []error{nil, errors.New("cannot open /tmp/a.go")} {
+		conn, err := Dial(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		errc := make(chan error)
+		go func() { errc <- Send(conn, want) }()
+		if got := <-reqs; !reflect.DeepEqual(got, want) {
+			t.Errorf("got request %+v, want %+v", got, want)
+		}
+		select {
+		case err := <-errc:
+			t.Fatalf("got response %v before the request was done", err)
+		default:
+		}
+		release <- tt
+		err = <-errc
+		if (err == nil) != (tt == nil) || err != nil && err.Error() != tt.Error() {
+			t.Errorf("got error %v, want %v", err, tt)
+		}
+	}
+}
+
+func TestListenStale(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "syd.sock")
+	l, err := Listen(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Listen(path); err == nil {
+		t.Fatal("listening twice on the same socket succeeded")
+	}
+	// Simulate a crashed syd that didn't remove its socket.
+	l.(interface{ SetUnlinkOnClose(bool) }).SetUnlinkOnClose(false)
+	l.Close()
+	l, err = Listen(path)
+	if err != nil {
+		t.Fatalf("stale socket not replaced: %v", err)
+	}
+	l.Close()
+}
+
+func TestFallbackDir(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	path := filepath.Join(fallbackDir(), "syd.sock")
+	l, err := Listen(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+
+	if err := os.Chmod(fallbackDir(), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Listen(path); err == nil {
+		t.Error("listening in a directory with mode 0755 succeeded")
+	}
+	if _, err := Dial(path); err == nil {
+		t.Error("dialing in a directory with mode 0755 succeeded")
+	}
+
+	other := t.TempDir()
+	if err := os.Chmod(other, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(fallbackDir()); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(other, fallbackDir()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Listen(path); err == nil {
+		t.Error("listening in a symlinked directory succeeded")
+	}
+}
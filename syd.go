@@ -1,34 +1,173 @@
 package main
 
 import (
+	"flag"
+	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
 
 	"github.com/mibk/syd/core"
+	"github.com/mibk/syd/remote"
 	"github.com/mibk/syd/ui/term"
 )
 
+// version is set at build time using -ldflags "-X main.version=...".
+var version = "devel"
+
 func main() {
 	log.SetPrefix("syd: ")
 	log.SetFlags(0)
+	os.Exit(run())
+}
+
+// run runs syd and returns the exit status.
+func run() int {
+	opts, err := parseArgs(os.Args[1:], os.Stderr)
+	if err == flag.ErrHelp {
+		return 0
+	} else if err != nil {
+		log.Println(err)
+		return 2
+	}
+	if opts.version {
+		fmt.Println("syd", version)
+		return 0
+	}
+
+	var stdin []byte
+	for _, f := range opts.files {
+		if f.name == "-" {
+			if stdin, err = ioutil.ReadAll(os.Stdin); err != nil {
+				log.Println("reading stdin:", err)
+				return 1
+			}
+			break
+		}
+	}
+
+	if !opts.newInstance && opts.load == "" && len(opts.files) > 0 {
+		if conn, err := remote.Dial(remote.SocketPath()); err == nil {
+			if err := remote.Send(conn, newRequest(opts, stdin)); err != nil {
+				log.Println(err)
+				return 1
+			}
+			return 0
+		}
+	}
 
 	ed := core.NewEditor()
 	ui := &term.UI{}
 	if err := ui.Init(ed); err != nil {
-		log.Fatalln("initializing ui:", err)
+		log.Println("initializing ui:", err)
+		return 1
 	}
+	// Errors are logged once the UI has closed
+	// and restored the terminal.
+	var fatal []interface{}
+	defer func() {
+		if fatal != nil {
+			log.Println(fatal...)
+		}
+	}()
 	defer ui.Close()
 
+	defer func() {
+		if e := recover(); e != nil {
+			// Save as much as possible so that the unsaved
+			// changes can be recovered.
+			ed.FlushJournals()
+			panic(e)
+		}
+	}()
+
 	ed.SetUI(ui)
-	col := ed.NewColumn()
-	if len(os.Args) == 1 {
-		col.NewWindow()
+	status := 0
+	if opts.load != "" {
+		if err := ed.LoadFile(opts.load); err != nil {
+			fatal = []interface{}{"loading dump:", err}
+			return 1
+		}
 	} else {
-		for _, a := range os.Args[1:] {
-			if _, err := col.NewWindowFile(a); err != nil {
-				panic(err)
+		wins := openFiles(ed, opts, stdin)
+		if opts.edit {
+			if len(wins) == 0 {
+				fatal = []interface{}{"cannot open", opts.files[0].name}
+				return 1
 			}
+			win := wins[0]
+			win.OnClose(func(dirty bool) {
+				if dirty || !win.Saved() {
+					status = 1
+				}
+				ed.Quit()
+			})
+		}
+	}
+	if !opts.newInstance {
+		l, err := remote.Listen(remote.SocketPath())
+		if err != nil {
+			ed.Errorf("listening for other syd processes: %v\n", err)
+		} else {
+			defer l.Close()
+			go ed.ServeRemote(l)
 		}
 	}
+	ed.OfferRecovery()
 	ui.Main()
+	ed.Close()
+	return status
+}
+
+// openFiles opens the files specified by opts, distributing them
+// among the requested number of columns, and returns the windows
+// that were opened.
+func openFiles(ed *core.Editor, opts *options, stdin []byte) (wins []*core.Window) {
+	cols := make([]*core.Column, opts.columns)
+	for i := range cols {
+		cols[i] = ed.NewColumn()
+		cols[i].SetX(float64(i) / float64(len(cols)))
+	}
+	if len(opts.files) == 0 {
+		cols[0].NewWindow()
+		return nil
+	}
+	for i, f := range opts.files {
+		col := cols[i*len(cols)/len(opts.files)]
+		var win *core.Window
+		if f.name == "-" {
+			win = col.NewWindowContent("+stdin", stdin)
+		} else {
+			var err error
+			if win, err = col.NewWindowFile(f.name); err != nil {
+				ed.Errorf("%v\n", err)
+				continue
+			}
+		}
+		win.SetReadOnly(opts.readOnly)
+		if f.addr != "" {
+			if err := win.SelectAddr(f.addr); err != nil {
+				ed.Errorf("%s: %v\n", f.name, err)
+			}
+		}
+		wins = append(wins, win)
+	}
+	return wins
+}
+
+// newRequest returns a request to open the files specified by opts
+// in a running syd.
+func newRequest(opts *options, stdin []byte) *remote.Request {
+	req := &remote.Request{ReadOnly: opts.readOnly, Wait: opts.wait, Edit: opts.edit}
+	for _, f := range opts.files {
+		rf := remote.File{Name: f.name, Addr: f.addr}
+		if f.name == "-" {
+			rf.Name, rf.Data = "+stdin", stdin
+		} else if abs, err := filepath.Abs(f.name); err == nil {
+			rf.Name = abs
+		}
+		req.Files = append(req.Files, rf)
+	}
+	return req
 }
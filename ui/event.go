@@ -2,6 +2,8 @@ package ui
 
 var Events = make(chan Event)
 
+// An Event is either an input event, Quit, or a func() that
+// is to be called by the UI goroutine.
 type Event interface{}
 
 var Quit = &struct{}{}
@@ -93,54 +93,115 @@ func (t *UI) translateEvents() {
 				continue
 			}
 
-			mod := termEv.Modifiers()
-			if mod&tcell.ModCtrl > 0 {
-				ev.Modifiers |= key.ModControl
-			}
-			if mod&tcell.ModAlt > 0 {
-				ev.Modifiers |= key.ModAlt
-			}
+			ev.Modifiers |= modifiers(termEv.Modifiers())
 			ui.Events <- ev
 		case *tcell.EventMouse:
 			x, y := termEv.Position()
 			ev := mouse.Event{
-				X: float32(x),
-				Y: float32(y),
+				X:         float32(x),
+				Y:         float32(y),
+				Modifiers: modifiers(termEv.Modifiers()),
 			}
 			btns := termEv.Buttons()
 			switch {
-			case btns == 0:
-				if t.wasBtnPressed {
-					// TODO: Send which button was released.
-					t.wasBtnPressed = false
-					ev.Direction = mouse.DirRelease
-				}
-				fallthrough
-			case t.wasBtnPressed:
-				ui.Events <- ev
-				continue
-			}
-			ev.Direction = mouse.DirPress
-			switch {
-			case btns&tcell.Button1 > 0:
-				ev.Button = mouse.ButtonLeft
-				t.wasBtnPressed = true
-			case btns&tcell.Button2 > 0:
-				ev.Button = mouse.ButtonMiddle
-				t.wasBtnPressed = true
-			case btns&tcell.Button3 > 0:
-				ev.Button = mouse.ButtonRight
-				t.wasBtnPressed = true
 			case btns&tcell.WheelUp > 0:
 				ev.Button = mouse.ButtonWheelUp
 				ev.Direction = mouse.DirStep
+				ui.Events <- ev
+				continue
 			case btns&tcell.WheelDown > 0:
 				ev.Button = mouse.ButtonWheelDown
 				ev.Direction = mouse.DirStep
-			default:
+				ui.Events <- ev
 				continue
 			}
-			ui.Events <- ev
+
+			// Send an event for each button pressed or released
+			// since the last event so that chords can be told.
+			btns &= tcell.Button1 | tcell.Button2 | tcell.Button3
+			changed := false
+			for _, b := range buttons {
+				switch {
+				case btns&b.mask != 0 && t.buttons&b.mask == 0:
+					ev.Direction = mouse.DirPress
+				case btns&b.mask == 0 && t.buttons&b.mask != 0:
+					ev.Direction = mouse.DirRelease
+				default:
+					continue
+				}
+				ev.Button = b.button
+				ui.Events <- ev
+				changed = true
+			}
+			t.buttons = btns
+			if !changed {
+				ev.Button, ev.Direction = mouse.ButtonNone, mouse.DirNone
+				ui.Events <- ev
+			}
 		}
 	}
 }
+
+var buttons = []struct {
+	mask   tcell.ButtonMask
+	button mouse.Button
+}{
+	{tcell.Button1, mouse.ButtonLeft},
+	{tcell.Button2, mouse.ButtonMiddle},
+	{tcell.Button3, mouse.ButtonRight},
+}
+
+// modifiers translates the modifier keys of a terminal event.
+func modifiers(mod tcell.ModMask) key.Modifiers {
+	var m key.Modifiers
+	if mod&tcell.ModCtrl > 0 {
+		m |= key.ModControl
+	}
+	if mod&tcell.ModAlt > 0 {
+		m |= key.ModAlt
+	}
+	return m
+}
+
+// terminalKey encodes the key press ev as the input of a program
+// running in a terminal. If appCursor is true, the cursor keys are
+// encoded in the application mode.
+func terminalKey(ev key.Event, appCursor bool) []byte {
+	var s string
+	switch ev.Rune {
+	case ui.KeyEnter:
+		s = "\r"
+	case ui.KeyBackspace:
+		s = "\x7f"
+	case ui.KeyDelete:
+		s = "\x1b[3~"
+	case ui.KeyEscape:
+		s = "\x1b"
+	case ui.KeyUp, ui.KeyDown, ui.KeyRight, ui.KeyLeft:
+		dir := map[rune]string{ui.KeyUp: "A", ui.KeyDown: "B", ui.KeyRight: "C", ui.KeyLeft: "D"}[ev.Rune]
+		if appCursor {
+			s = "\x1bO" + dir
+		} else {
+			s = "\x1b[" + dir
+		}
+	case ui.KeyPageUp:
+		s = "\x1b[5~"
+	case ui.KeyPageDown:
+		s = "\x1b[6~"
+	default:
+		r := ev.Rune
+		if ev.Modifiers&key.ModControl != 0 {
+			switch {
+			case r >= 'a' && r <= 'z':
+				r -= 'a' - 1
+			case r == ' ':
+				r = 0
+			}
+		}
+		s = string(r)
+	}
+	if ev.Modifiers&key.ModAlt != 0 {
+		s = "\x1b" + s
+	}
+	return []byte(s)
+}
@@ -3,6 +3,7 @@ package term
 import (
 	"fmt"
 	"io"
+	"math"
 	"sort"
 	"time"
 	"unicode"
@@ -11,10 +12,10 @@ import (
 	"golang.org/x/mobile/event/key"
 	"golang.org/x/mobile/event/mouse"
 
-	"github.com/atotto/clipboard"
 	"github.com/gdamore/tcell"
 	"github.com/mibk/syd/core"
 	"github.com/mibk/syd/ui"
+	"github.com/mibk/syd/vt"
 )
 
 var (
@@ -28,7 +29,15 @@ var (
 	bodybg = tcell.StyleDefault.Background(tcell.GetColor("#ffffea"))
 	bodyhl = tcell.StyleDefault.Background(tcell.GetColor("#e0e090"))
 
+	matchstyle = tcell.StyleDefault.Background(tcell.GetColor("#c0e0ff")) // search matches
+
+	// ranges swept by the buttons 2 and 3
+	execstyle  = tcell.StyleDefault.Background(tcell.GetColor("#aa0000")).Foreground(tcell.ColorWhite)
+	plumbstyle = tcell.StyleDefault.Background(tcell.GetColor("#006600")).Foreground(tcell.ColorWhite)
+
 	testbg = tcell.StyleDefault.Background(tcell.GetColor("#ffe0ff"))
+
+	escfg = tcell.GetColor("#c00000") // escaped runes
 )
 
 type reloader interface {
@@ -39,7 +48,7 @@ type UI struct {
 	screen tcell.Screen
 	model  *core.Editor
 
-	wasBtnPressed bool
+	buttons tcell.ButtonMask // mouse buttons being held
 
 	width  int
 	height int
@@ -47,9 +56,44 @@ type UI struct {
 	tag      *Text
 	firstCol *Column
 
-	grabbedCol *Column // grabbed col or nil
-	grabbedWin *Window // grabbed win or nil
-	activeText *Text   // will receive key events
+	grabbedCol    *Column // grabbed col or nil
+	grabbedWin    *Window // grabbed win or nil
+	grabbedBorder *Window // win whose border is grabbed or nil
+	activeText    *Text   // will receive key events
+
+	grab struct {
+		x, y   int // where the grab started
+		button mouse.Button
+	}
+
+	chord   chord // mouse buttons held
+	argText *Text // text of the last selection made by button 1
+}
+
+// forget drops the references to the texts of a deleted
+// window or column, whose content mustn't be read anymore.
+func (t *UI) forget(texts ...*Text) {
+	for _, text := range texts {
+		if t.argText == text {
+			t.argText = nil
+		}
+		if t.chord.text == text {
+			t.chord = chord{buttons: t.chord.buttons}
+		}
+	}
+}
+
+// A chord tracks the mouse buttons pressed in a text while
+// the first of them is held.
+type chord struct {
+	text    *Text
+	buttons uint // 1<<button for each held button
+	first   mouse.Button
+	q       int64 // where the first button was pressed
+	q1      int64 // where the range swept by the button 2 or 3 ends
+
+	arg    bool // 2-1: execute with the argument
+	cancel bool // 2-3: don't execute
 }
 
 func (t *UI) Init(m ui.Model) error {
@@ -68,6 +112,7 @@ func (t *UI) Init(m ui.Model) error {
 		ui:      t,
 		parent:  t,
 		frame:   new(Frame),
+		istag:   true,
 		bgstyle: tagbg,
 		hlstyle: taghl,
 	}
@@ -77,6 +122,7 @@ func (t *UI) Init(m ui.Model) error {
 	w, h := t.Size()
 	t.width = w - 1
 	t.height = h - 2
+	t.model.SetMinColumnWidth(minColWidth / float64(t.width))
 
 	go t.translateEvents()
 	return nil
@@ -100,6 +146,8 @@ func (t *UI) Main() {
 			t.activeText.handleKeyEvent(ev)
 		case mouse.Event:
 			t.handleMouseEvent(ev)
+		case func():
+			ev()
 		}
 	}
 }
@@ -130,6 +178,17 @@ func (t *UI) clear() {
 // TODO: Just for testing purposes; remove.
 const ui_y = 1
 
+// minColWidth is the minimal width of a column in cells.
+const minColWidth = 12
+
+// growWindow maps the buttons clicking the layout box of a window
+// to the ways of growing it.
+var growWindow = map[mouse.Button]int{
+	mouse.ButtonLeft:   core.GrowSome,
+	mouse.ButtonMiddle: core.GrowMax,
+	mouse.ButtonRight:  core.GrowFull,
+}
+
 func (t *UI) flush() {
 	t.tag.x = ui_y
 	t.tag.y = ui_y
@@ -159,6 +218,23 @@ func (t *UI) flush() {
 }
 
 func (t *UI) handleMouseEvent(ev mouse.Event) {
+	// The buttons are tracked here, not in the texts, so that
+	// the chord ends even if a button is released elsewhere.
+	c := &t.chord
+	switch ev.Direction {
+	case mouse.DirPress:
+		c.buttons |= 1 << uint(ev.Button)
+	case mouse.DirRelease:
+		c.buttons &^= 1 << uint(ev.Button)
+	}
+	t.sendMouseEvent(ev)
+	if c.buttons == 0 {
+		*c = chord{}
+	}
+}
+
+// sendMouseEvent sends ev to the column or the tag under the mouse.
+func (t *UI) sendMouseEvent(ev mouse.Event) {
 	y := int(ev.Y)
 	if y < t.y() {
 		t.tag.handleMouseEvent(ev)
@@ -184,6 +260,7 @@ func (t *UI) NewColumn(m ui.Model) ui.Column {
 	tag := &Text{
 		ui:      t,
 		frame:   new(Frame),
+		istag:   true,
 		bgstyle: tagbg,
 		hlstyle: taghl,
 	}
@@ -215,10 +292,41 @@ func (t *UI) lastCol() *Column {
 	return col
 }
 
-func (t *UI) moveGrabbedCol(x int) {
+// startGrab grabs a column, a window or its border at the position
+// of the mouse press ev.
+func (t *UI) startGrab(ev mouse.Event) {
+	t.grab.x, t.grab.y = int(ev.X), int(ev.Y)
+	t.grab.button = ev.Button
+}
+
+// clicked reports whether the grab has been released at x, y,
+// where it started.
+func (t *UI) clicked(x, y int) bool { return x == t.grab.x && y == t.grab.y }
+
+// resizeGrabbedBorder moves the top of the window whose border is
+// grabbed and the left edge of its column along the mouse released
+// at x, y.
+func (t *UI) resizeGrabbedBorder(x, y int) {
+	win := t.grabbedBorder
+	t.grabbedBorder = nil
+	col := win.col
+	if dy := y - t.grab.y; dy != 0 {
+		col.model.ResizeWindow(win.model, float64(win.y()+dy)/float64(col.height()))
+	}
+	if dx := x - t.grab.x; dx != 0 {
+		t.model.ResizeColumn(col.model, float64(col.x()+dx)/float64(t.width))
+	}
+}
+
+func (t *UI) moveGrabbedCol(x, y int) {
 	gc := t.grabbedCol
 	t.grabbedCol = nil
 
+	if t.clicked(x, y) {
+		// Clicking the box of the column grows it.
+		t.model.GrowColumn(gc.model, t.grab.button != mouse.ButtonLeft)
+		return
+	}
 	t.model.MoveColumn(gc.model, float64(x)/float64(t.width))
 
 	// TODO: Just a temporary hack.
@@ -228,7 +336,7 @@ func (t *UI) moveGrabbedCol(x int) {
 		cols = append(cols, col)
 		col = col.next
 	}
-	sort.Slice(cols, func(i, j int) bool {
+	sort.SliceStable(cols, func(i, j int) bool {
 		return cols[i].model.X() < cols[j].model.X()
 	})
 
@@ -289,18 +397,24 @@ func (col *Column) handleMouseEvent(ev mouse.Event) {
 
 	if col.ui.grabbedCol != nil {
 		if ev.Direction == mouse.DirRelease {
-			col.ui.moveGrabbedCol(x)
+			col.ui.moveGrabbedCol(x, y)
 		}
 		return
 	} else if col.ui.grabbedWin != nil {
 		if ev.Direction == mouse.DirRelease {
-			col.moveGrabbedWin(y - col.y())
+			col.moveGrabbedWin(x, y)
+		}
+		return
+	} else if col.ui.grabbedBorder != nil {
+		if ev.Direction == mouse.DirRelease {
+			col.ui.resizeGrabbedBorder(x, y)
 		}
 		return
 	}
 
 	if ev.Direction == mouse.DirPress && x == col.x() && y == col.ui.y() {
 		col.ui.grabbedCol = col
+		col.ui.startGrab(ev)
 		return
 	}
 
@@ -312,16 +426,22 @@ func (col *Column) handleMouseEvent(ev mouse.Event) {
 
 	win := col.firstWin
 	for win != nil {
-		if winY := win.y(); y < winY || y >= winY+win.height() {
+		if winY := col.y() + win.y(); y < winY || y >= winY+win.height() {
 			win = win.next
 			continue
 		}
 		if y >= win.body.y {
+			if ev.Direction == mouse.DirPress && x == col.x() {
+				col.ui.grabbedBorder = win
+				col.ui.startGrab(ev)
+				break
+			}
 			win.body.handleMouseEvent(ev)
 			col.ui.activeText = win.body
 		} else {
 			if ev.Direction == mouse.DirPress && x == win.col.x() && y == win.tag.y {
 				col.ui.grabbedWin = win
+				col.ui.startGrab(ev)
 				break
 			}
 			win.tag.handleMouseEvent(ev)
@@ -336,6 +456,7 @@ func (col *Column) NewWindow(m ui.Model) ui.Updater {
 	tag := &Text{
 		ui:      col.ui,
 		frame:   new(Frame),
+		istag:   true,
 		bgstyle: tagbg,
 		hlstyle: taghl,
 	}
@@ -372,6 +493,7 @@ func (col *Column) NewWindow(m ui.Model) ui.Updater {
 func (col *Column) Update(msg ui.Message) {
 	switch msg {
 	case ui.Delete:
+		col.ui.forget(col.tag)
 		col.ui.removeCol(col)
 	default:
 		panic(fmt.Sprintf("unexpected message: %v", msg))
@@ -383,6 +505,11 @@ func (col *Column) reload() error {
 	col.tag.reload()
 	win := col.firstWin
 	for win != nil {
+		if win.height() <= 0 {
+			// Hidden by a grown window.
+			win = win.next
+			continue
+		}
 		if err := win.reload(); err != nil {
 			return err
 		}
@@ -421,9 +548,14 @@ func (col *Column) flush() {
 		}
 		return
 	}
+	if h := col.height(); h > 0 {
+		col.model.SetLineHeight(1 / float64(h))
+	}
 	win := col.firstWin
 	for win != nil {
-		win.flush()
+		if win.height() > 0 {
+			win.flush()
+		}
 		win = win.next
 	}
 }
@@ -439,11 +571,16 @@ func (col *Column) lastWin() *Window {
 	return win
 }
 
-func (col *Column) moveGrabbedWin(y int) {
+func (col *Column) moveGrabbedWin(x, y int) {
 	gw := col.ui.grabbedWin
 	col.ui.grabbedWin = nil
 
-	col.model.MoveWindow(gw.model, float64(y)/float64(col.ui.height))
+	if col.ui.clicked(x, y) {
+		// Clicking the box of the window grows it.
+		gw.col.model.GrowWindow(gw.model, growWindow[col.ui.grab.button])
+		return
+	}
+	col.model.MoveWindow(gw.model, float64(y-col.y())/float64(col.height()))
 
 	// TODO: Just a temporary hack.
 	var wins []*Window
@@ -452,7 +589,7 @@ func (col *Column) moveGrabbedWin(y int) {
 		wins = append(wins, win)
 		win = win.next
 	}
-	sort.Slice(wins, func(i, j int) bool {
+	sort.SliceStable(wins, func(i, j int) bool {
 		return wins[i].model.Y() < wins[j].model.Y()
 	})
 
@@ -505,12 +642,16 @@ type Window struct {
 	next *Window
 }
 
+// Window's y relative to the content of its column, whose
+// height the position of the window is a fraction of.
 func (win *Window) y() int {
-	return int(win.model.Y() * float64(win.col.ui.height))
+	return int(math.Round(win.model.Y() * float64(win.col.height())))
 }
 
 func (win *Window) sety(y int) {
-	win.model.SetY(float64(y) / float64(win.col.ui.height))
+	if h := win.col.height(); h > 0 {
+		win.model.SetY(float64(y) / float64(h))
+	}
 }
 
 func (win *Window) reload() error {
@@ -518,6 +659,9 @@ func (win *Window) reload() error {
 	if err := win.tag.reload(); err != nil {
 		return err
 	}
+	if win.body.model.Terminal() != nil {
+		return nil
+	}
 	if err := win.body.reload(); err != nil {
 		return err
 	}
@@ -539,6 +683,7 @@ func (win *Window) clear() {
 func (win *Window) Update(msg ui.Message) {
 	switch msg {
 	case ui.Delete:
+		win.col.ui.forget(win.tag, win.body)
 		win.col.removeWin(win)
 	default:
 		panic(fmt.Sprintf("unexpected message: %v", msg))
@@ -574,6 +719,11 @@ func (win *Window) flush() {
 	}
 	win.body.x = win.col.x() + 1
 	win.body.y = winy + h
+	if tm := win.body.model.Terminal(); tm != nil {
+		win.body.model.ResizeTerminal(win.body.width, win.body.height)
+		win.body.flushTerminal(tm)
+		return
+	}
 	win.body.flush()
 	win.body.fill()
 }
@@ -596,6 +746,7 @@ type Text struct {
 	// and the tag changes the number of lines). Revalidate
 	// whether this is still true once the ui API settles down.
 	parent reloader
+	istag  bool
 
 	width, height int
 	x, y          int
@@ -617,21 +768,23 @@ func (t *Text) init(m ui.Model) {
 }
 
 func (t *Text) handleKeyEvent(ev key.Event) {
+	if tm := t.model.Terminal(); tm != nil {
+		t.model.SendTerminal(terminalKey(ev, tm.AppCursorKeys()))
+		return
+	}
+	if t.model.Searching() && t.handleSearchKey(ev) {
+		t.checkVisibility()
+		return
+	}
 	switch {
 	case ev.Rune == ui.KeyEnter:
 		t.model.InsertNewLine()
 		t.checkVisibility()
 	case ev.Rune == ui.KeyBackspace:
-		q0, q1 := t.model.Selected()
-		if q0 == q1 {
-			t.sel(q0-1, q1)
-		}
+		t.model.ExtendEmpty(-1)
 		t.deleteSel()
 	case ev.Rune == ui.KeyDelete:
-		q0, q1 := t.model.Selected()
-		if q0 == q1 {
-			t.sel(q0, q1+1)
-		}
+		t.model.ExtendEmpty(1)
 		t.deleteSel()
 	case ev.Rune == ui.KeyEscape:
 		t.deleteSel()
@@ -645,26 +798,68 @@ func (t *Text) handleKeyEvent(ev key.Event) {
 	case ev.Rune == ui.KeyDown:
 		t.down()
 
-	case (ev.Rune == 'c' || ev.Rune == 'x') && ev.Modifiers&key.ModControl != 0:
-		s := t.model.SelectionToString(t.model.Selected())
-		err := clipboard.WriteAll(s)
-		if err != nil {
-			panic(err)
-		}
-		if ev.Rune == 'x' {
-			t.deleteSel()
-		}
+	case ev.Rune == 'c' && ev.Modifiers&key.ModControl != 0:
+		t.model.Snarf()
+	case ev.Rune == 'x' && ev.Modifiers&key.ModControl != 0:
+		t.model.Cut()
+		t.checkVisibility()
 	case ev.Rune == 'v' && ev.Modifiers&key.ModControl != 0:
-		s, err := clipboard.ReadAll()
-		if err != nil {
-			panic(err)
-		}
-		t.insert(s)
+		t.model.Paste()
+		t.frame.SetWantCol(ui.ColQ1)
+		t.checkVisibility()
+	case ev.Rune == 's' && ev.Modifiers&key.ModControl != 0:
+		t.model.StartSearch(1)
+		t.checkVisibility()
+	case ev.Rune == 'r' && ev.Modifiers&key.ModControl != 0:
+		t.model.StartSearch(-1)
+		t.checkVisibility()
+	case ev.Rune == 'p' && ev.Modifiers&key.ModControl != 0:
+		t.model.History(-1)
+		t.checkVisibility()
+	case ev.Rune == 'n' && ev.Modifiers&key.ModControl != 0:
+		t.model.History(1)
+		t.checkVisibility()
 	default:
 		t.insert(string(ev.Rune))
 	}
 }
 
+// handleSearchKey handles the key press ev during an incremental
+// search: Ctrl-S and Ctrl-R select the next and the previous match,
+// Alt-R toggles regular expressions, Alt-C case-insensitivity, Enter
+// ends the search and Escape cancels it. It reports false if ev ends
+// the search and is to be handled as usual.
+func (t *Text) handleSearchKey(ev key.Event) bool {
+	ctrl := ev.Modifiers&key.ModControl != 0
+	alt := ev.Modifiers&key.ModAlt != 0
+	switch {
+	case ctrl && ev.Rune == 's':
+		t.model.SearchNext(1)
+	case ctrl && ev.Rune == 'r':
+		t.model.SearchNext(-1)
+	case alt && ev.Rune == 'r':
+		t.model.ToggleSearchRegexp()
+	case alt && ev.Rune == 'c':
+		t.model.ToggleSearchCase()
+	case ev.Rune == ui.KeyBackspace:
+		t.model.SearchDelete()
+	case ev.Rune == ui.KeyEnter:
+		t.model.StopSearch(true)
+	case ev.Rune == ui.KeyEscape:
+		t.model.StopSearch(false)
+	case ctrl, alt,
+		ev.Rune == ui.KeyUp, ev.Rune == ui.KeyDown,
+		ev.Rune == ui.KeyLeft, ev.Rune == ui.KeyRight,
+		ev.Rune == ui.KeyPageUp, ev.Rune == ui.KeyPageDown,
+		ev.Rune == ui.KeyDelete:
+		t.model.StopSearch(true)
+		return false
+	default:
+		t.model.SearchAppend(string(ev.Rune))
+	}
+	return true
+}
+
 func (t *Text) sel(q0, q1 int64) {
 	t.model.Select(q0, q1)
 	t.checkVisibility()
@@ -738,26 +933,73 @@ func (t *Text) findQ(line int) int64 {
 }
 
 func (t *Text) handleMouseEvent(ev mouse.Event) {
+	if t.model.Terminal() != nil {
+		return
+	}
+	if ev.Direction == mouse.DirPress {
+		t.model.StopSearch(true)
+	}
 	p := t.frame.CharsUntilXY(int(ev.X)-t.x, int(ev.Y)-t.y)
 	q := t.model.Origin() + int64(p)
 
+	c := &t.ui.chord
 	switch ev.Direction {
 	case mouse.DirPress:
+		if c.text != nil && c.buttons != 1<<uint(ev.Button) {
+			c.text.chorded(ev.Button)
+			return
+		}
+		*c = chord{text: t, buttons: c.buttons, first: ev.Button, q: q, q1: q}
 		switch {
-		case ev.Button == mouse.ButtonMiddle:
-			t.model.ExecuteUnderCursor(q)
-		case ev.Button == mouse.ButtonRight:
-			t.model.Plumb(q)
+		case ev.Button == mouse.ButtonMiddle, ev.Button == mouse.ButtonRight:
+			// The button can sweep a range to execute
+			// or plumb, which is done once it's released.
+		case ev.Modifiers&key.ModAlt != 0:
+			t.model.StartBlock(q, t.frame.runeWidth)
+			t.ui.argText = t
 		case time.Since(t.timestamp) < 300*time.Millisecond:
 			t.model.SelectUnderCursor(q)
+			t.ui.argText = t
 		default:
 			t.timestamp = time.Now()
 			t.model.StartSel(q)
 			t.frame.SetWantCol(ui.ColQ0)
+			t.ui.argText = t
 		}
 	case mouse.DirRelease:
-		t.model.StopSel()
+		if c.text == nil {
+			t.model.StopSel()
+			return
+		}
+		if ev.Button == mouse.ButtonLeft {
+			c.text.model.StopSel()
+		}
+		if c.buttons != 0 || c.cancel {
+			return
+		}
+		q0, q1 := c.swept()
+		switch c.first {
+		case mouse.ButtonMiddle:
+			var arg string
+			if a := t.ui.argText; c.arg && a != nil {
+				arg = a.model.SelectionToString(a.model.Selected())
+			}
+			if q0 == q1 {
+				c.text.model.ExecuteWithArg(c.q, arg)
+			} else {
+				c.text.model.ExecuteRange(q0, q1, arg)
+			}
+		case mouse.ButtonRight:
+			if q0 == q1 {
+				c.text.model.Plumb(c.q)
+			} else {
+				c.text.model.PlumbRange(q0, q1)
+			}
+		}
 	case mouse.DirNone:
+		if c.buttons != 0 && c.text == t {
+			c.q1 = q
+		}
 		t.model.MoveSel(q)
 	case mouse.DirStep:
 		const nlines = 3
@@ -772,10 +1014,67 @@ func (t *Text) handleMouseEvent(ev mouse.Event) {
 	}
 }
 
+// swept returns the range swept by the first button.
+func (c *chord) swept() (q0, q1 int64) {
+	if c.q1 < c.q {
+		return c.q1, c.q
+	}
+	return c.q, c.q1
+}
+
+// sweepStyle returns the style of the range swept by the button
+// 2 or 3 in t, if it's being swept.
+func (t *Text) sweepStyle() (style tcell.Style, q0, q1 int64, ok bool) {
+	c := &t.ui.chord
+	if c.text != t || c.buttons == 0 || c.cancel {
+		return style, 0, 0, false
+	}
+	switch c.first {
+	case mouse.ButtonMiddle:
+		style = execstyle
+	case mouse.ButtonRight:
+		style = plumbstyle
+	default:
+		return style, 0, 0, false
+	}
+	q0, q1 = c.swept()
+	return style, q0, q1, q0 != q1
+}
+
+// chorded handles the button btn pressed while another one,
+// which was pressed first in t, is held: 1-2 cuts the selection,
+// 1-3 pastes, and so 1-2-3 snarfs it. 2-1 executes the command
+// with the last selection made by button 1 as its argument,
+// and 2-3 cancels the command.
+func (t *Text) chorded(btn mouse.Button) {
+	c := &t.ui.chord
+	switch {
+	case c.first == mouse.ButtonLeft && btn == mouse.ButtonMiddle:
+		t.model.StopSel()
+		t.model.Cut()
+	case c.first == mouse.ButtonLeft && btn == mouse.ButtonRight:
+		t.model.StopSel()
+		q0, _ := t.model.Selected()
+		t.model.Paste()
+		if len(t.model.Selections()) == 1 {
+			// Select the pasted text so that it can
+			// be cut again by 2.
+			_, q1 := t.model.Selected()
+			t.model.Select(q0, q1)
+		}
+	case c.first == mouse.ButtonMiddle && btn == mouse.ButtonLeft:
+		c.arg = true
+	case c.first == mouse.ButtonMiddle && btn == mouse.ButtonRight:
+		c.cancel = true
+	}
+}
+
 func (t *Text) clear() {
 	*t.frame = Frame{
 		lines:   make([][]rune, 1),
 		wantCol: t.frame.wantCol,
+		hex:     t.model.HexMode(),
+		tag:     t.istag,
 	}
 	t.cur.x, t.cur.y = 0, 0
 
@@ -806,19 +1105,20 @@ func (t *Text) reload() error {
 }
 
 func (t *Text) writeRune(r rune) error {
-	t.frame.lines[t.cur.y] = append(t.frame.lines[t.cur.y], r)
-	if r == '\t' {
-		t.cur.x += tabWidthForCol(t.cur.x)
-	} else {
-		t.cur.x++
+	w := t.frame.runeWidth(r, t.cur.x)
+	if t.cur.x > 0 && t.cur.x+w > t.width && r != '\t' && r != '\n' {
+		// Don't split escaped runes and hex bytes.
+		if err := t.newLine(); err != nil {
+			return err
+		}
+		w = t.frame.runeWidth(r, 0)
 	}
+	t.frame.lines[t.cur.y] = append(t.frame.lines[t.cur.y], r)
+	t.cur.x += w
 
-	if t.cur.x >= t.width || r == '\n' {
-		t.cur.y++
-		t.cur.x = 0
-		t.frame.lines = append(t.frame.lines, nil)
-		if t.cur.y == t.height {
-			return io.EOF
+	if t.cur.x >= t.width || r == '\n' && !t.frame.hex {
+		if err := t.newLine(); err != nil {
+			return err
 		}
 	}
 
@@ -827,6 +1127,16 @@ func (t *Text) writeRune(r rune) error {
 	return nil
 }
 
+func (t *Text) newLine() error {
+	t.cur.y++
+	t.cur.x = 0
+	t.frame.lines = append(t.frame.lines, nil)
+	if t.cur.y == t.height {
+		return io.EOF
+	}
+	return nil
+}
+
 // checkSelection tries to line0, line1, and wantCol.
 func (t *Text) checkSelection() {
 	if t.cur.p0 == t.frame.nchars {
@@ -846,12 +1156,44 @@ func (t *Text) checkSelection() {
 var reverse = tcell.StyleDefault.Reverse(true)
 
 func (t *Text) flush() {
+	origin := t.model.Origin()
+	matches := t.model.SearchMatches(origin, origin+int64(t.frame.nchars))
+	inMatch := func(p int) bool {
+		q := origin + int64(p)
+		for len(matches) > 0 && matches[0][1] <= q {
+			matches = matches[1:]
+		}
+		return len(matches) > 0 && matches[0][0] <= q
+	}
+
+	// All the selections are drawn the same; the cursor of each
+	// empty one is reversed.
+	sels := t.model.Selections()
+	inSel := func(p int) (cursor, in bool) {
+		q := origin + int64(p)
+		for len(sels) > 0 && (sels[0][1] < q || sels[0][1] == q && sels[0][0] < q) {
+			sels = sels[1:]
+		}
+		if len(sels) == 0 {
+			return false, false
+		}
+		s := sels[0]
+		return s[0] == q && s[1] == q, s[0] <= q && q < s[1]
+	}
+
+	sweepstyle, sq0, sq1, sweeping := t.sweepStyle()
+
 	style := t.bgstyle
 	selStyle := func(p int) {
-		if p == t.cur.p0 && t.cur.p0 == t.cur.p1 {
+		cursor, in := inSel(p)
+		if q := origin + int64(p); sweeping && sq0 <= q && q < sq1 {
+			style = sweepstyle
+		} else if cursor {
 			style = reverse
-		} else if p >= t.cur.p0 && p < t.cur.p1 {
+		} else if in {
 			style = t.hlstyle
+		} else if inMatch(p) {
+			style = matchstyle
 		} else {
 			style = t.bgstyle
 		}
@@ -863,18 +1205,34 @@ func (t *Text) flush() {
 			selStyle(p)
 			p++
 			w := 1
+			var glyphs string
 			switch {
+			case t.frame.hex:
+				glyphs = hexBytes(r)
 			case r == '\n':
 				goto fill
 			case r == '\t':
 				r = ' '
 				w = tabWidthForCol(x)
-			case r == 0:
+			case r == 0 && t.istag:
 				// TODO: This is a workaround to print silently \0 that
 				// separates filename and commands in the tag of the window.
 				r = ' '
-			case !unicode.IsPrint(r):
-				r = utf8.RuneError
+			case !isPrint(r):
+				glyphs = escape(r)
+				style = style.Foreground(escfg)
+			}
+			if glyphs != "" {
+				for _, g := range glyphs {
+					if x < t.width {
+						t.ui.screen.SetContent(t.x+x, t.y+y, g, nil, style)
+					}
+					x++
+					if style == reverse {
+						style = t.bgstyle
+					}
+				}
+				continue
 			}
 			for i := 0; i < w && x < t.width; i++ {
 				// TODO: Should the rest of the tab at the end of a
@@ -897,6 +1255,44 @@ func (t *Text) flush() {
 	}
 }
 
+// flushTerminal draws the screen of the terminal tm instead
+// of the text.
+func (t *Text) flushTerminal(tm *vt.Terminal) {
+	cols, rows := tm.Size()
+	cx, cy, visible := tm.Cursor()
+	for y := 0; y < t.height; y++ {
+		for x := 0; x < t.width; x++ {
+			r, style := ' ', t.bgstyle
+			if x < cols && y < rows {
+				c := tm.Cell(x, y)
+				if c.Rune == 0 {
+					// The second half of a wide rune.
+					continue
+				}
+				r, style = c.Rune, t.cellStyle(c.Attr)
+				if visible && x == cx && y == cy {
+					style = style.Reverse(!c.Reverse)
+				}
+			}
+			t.ui.screen.SetContent(t.x+x, t.y+y, r, nil, style)
+		}
+	}
+}
+
+// cellStyle returns the style of a terminal cell. The default colors
+// are those of the text. The colors of vt and tcell share the same
+// encoding.
+func (t *Text) cellStyle(a vt.Attr) tcell.Style {
+	style := t.bgstyle
+	if a.Fg != vt.DefaultColor {
+		style = style.Foreground(tcell.Color(a.Fg))
+	}
+	if a.Bg != vt.DefaultColor {
+		style = style.Background(tcell.Color(a.Bg))
+	}
+	return style.Bold(a.Bold).Underline(a.Underline).Reverse(a.Reverse)
+}
+
 func (t *Text) fill() {
 	// TODO: Using this bg color just for testing purposes.
 	bg := testbg
@@ -907,12 +1303,59 @@ func (t *Text) fill() {
 	}
 }
 
+// isPrint reports whether r can be printed as is.
+func isPrint(r rune) bool {
+	if _, ok := core.RawByte(r); ok {
+		return false
+	}
+	return unicode.IsPrint(r)
+}
+
+// escape returns the printable form of r: invalid bytes and
+// ASCII control characters are escaped as \xNN, the other
+// runes as \uNNNN.
+func escape(r rune) string {
+	if b, ok := core.RawByte(r); ok {
+		return fmt.Sprintf(`\x%02x`, b)
+	}
+	if r < utf8.RuneSelf {
+		return fmt.Sprintf(`\x%02x`, r)
+	}
+	return fmt.Sprintf(`\u%04x`, r)
+}
+
+// hexBytes returns the bytes encoding r as hexadecimal numbers,
+// each followed by a space.
+func hexBytes(r rune) string {
+	if b, ok := core.RawByte(r); ok {
+		return fmt.Sprintf("%02x ", b)
+	}
+	return fmt.Sprintf("% x ", string(r))
+}
+
 type Frame struct {
 	lines   [][]rune
 	line0   int
 	line1   int
 	wantCol int
 	nchars  int
+
+	hex bool // display runes as hexadecimal bytes
+	tag bool
+}
+
+// runeWidth returns the number of cells r occupies when drawn
+// at the column col.
+func (f *Frame) runeWidth(r rune, col int) int {
+	switch {
+	case f.hex:
+		return 3 * core.RuneLen(r)
+	case r == '\t':
+		return tabWidthForCol(col)
+	case r == '\n', r == 0 && f.tag, isPrint(r):
+		return 1
+	}
+	return len(escape(r))
 }
 
 func (f *Frame) Nchars() int                { return f.nchars }
@@ -925,29 +1368,25 @@ func (f *Frame) CharsUntilXY(x, y int) int {
 	var p int
 	for n, l := range f.lines {
 		if n == y {
-			return p + charsUntilX(l, x)
+			return p + f.charsUntilX(l, x)
 		}
 		p += len(l)
 	}
 	return 0
 }
 
-func charsUntilX(s []rune, x int) int {
+func (f *Frame) charsUntilX(s []rune, x int) int {
 	if len(s) == 0 {
 		return 0
 	}
 	var w int
 	for i, r := range s {
-		if r == '\t' {
-			w += tabWidthForCol(w)
-		} else {
-			w += 1
-		}
+		w += f.runeWidth(r, w)
 		if w > x {
 			return i
 		}
 	}
-	if s[len(s)-1] == '\n' {
+	if s[len(s)-1] == '\n' && !f.hex {
 		return len(s) - 1
 	}
 	return len(s)
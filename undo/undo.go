@@ -82,6 +82,7 @@ package undo
 import (
 	"errors"
 	"io"
+	"sort"
 	"time"
 )
 
@@ -426,6 +427,56 @@ func (b *Buffer) Size() int64 {
 	return size
 }
 
+// A Snapshot is a read-only view of the content of a buffer at the time
+// the snapshot was taken. It shares the data with the buffer.
+type Snapshot struct {
+	pieces [][]byte
+	starts []int64 // offset of each piece
+	size   int64
+}
+
+// Snapshot returns a snapshot of the current content of the buffer.
+// Only the most recently modified piece is changed in place, so it's
+// copied, and the snapshot can be read by other goroutines while the
+// buffer is being modified.
+func (b *Buffer) Snapshot() *Snapshot {
+	s := new(Snapshot)
+	for p := b.begin; p != nil; p = p.next {
+		if p.len() == 0 {
+			continue
+		}
+		data := p.data
+		if p == b.cachedPiece {
+			data = append([]byte(nil), data...)
+		}
+		s.pieces = append(s.pieces, data)
+		s.starts = append(s.starts, s.size)
+		s.size += int64(p.len())
+	}
+	return s
+}
+
+func (s *Snapshot) ReadAt(data []byte, off int64) (n int, err error) {
+	if off < 0 || off > s.size {
+		return 0, ErrWrongOffset
+	}
+	if off == s.size {
+		return 0, io.EOF
+	}
+	i := sort.Search(len(s.starts), func(i int) bool { return s.starts[i] > off }) - 1
+	for ; n < len(data) && i < len(s.pieces); i++ {
+		n += copy(data[n:], s.pieces[i][off-s.starts[i]:])
+		off = s.starts[i] + int64(len(s.pieces[i]))
+	}
+	if n < len(data) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Size returns the size of the snapshot.
+func (s *Snapshot) Size() int64 { return s.size }
+
 // action is a list of changes which are used to undo/redo all modifications.
 type action struct {
 	changes []*change
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"strings"
 	"testing"
 )
@@ -370,3 +371,40 @@ func (t *Buffer) allContent() string {
 	}
 	return string(data)
 }
+
+func TestSnapshot(t *testing.T) {
+	b := NewBuffer([]byte("So many books, so little time."))
+	b.insertString(8, "good ")
+	b.Delete(0, 3)
+	b.Commit()
+	b.insertString(15, "!?")
+	s := b.Snapshot()
+	want := "many good books!?, so little time."
+
+	// Later changes mustn't affect the snapshot, not even those
+	// modifying the piece that hasn't been committed.
+	b.cacheInsertString(16, "x")
+	b.insertString(4, " more")
+	b.cacheInsertString(9, "!")
+	b.Delete(0, 10)
+	b.Undo()
+
+	if s.Size() != int64(len(want)) {
+		t.Errorf("got size %d, want %d", s.Size(), len(want))
+	}
+	got, err := ioutil.ReadAll(io.NewSectionReader(s, 0, s.Size()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	data := make([]byte, 9)
+	n, err := s.ReadAt(data, 5)
+	if err != nil || string(data[:n]) != "good book" {
+		t.Errorf("got %q, %v, want %q", data[:n], err, "good book")
+	}
+	if _, err := NewBuffer(nil).Snapshot().ReadAt(data, 0); err != io.EOF {
+		t.Errorf("got %v, want EOF", err)
+	}
+}
@@ -0,0 +1,856 @@
+// Package vt implements a terminal emulator understanding the subset
+// of the VT100 and xterm control sequences used by common full-screen
+// programs such as less, htop or vi.
+//
+// The output of a program is written to a Terminal, which keeps the
+// resulting screen as a grid of cells. Replies to the queries of the
+// program, such as the cursor position report, are written to the
+// writer passed to New.
+package vt
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mattn/go-runewidth"
+)
+
+// A Color is a color of a cell. Colors 0 to 255 are indices to the
+// xterm palette, colors with the IsRGB bit set hold the red, green
+// and blue components in the lower 24 bits.
+type Color int32
+
+const (
+	DefaultColor Color = -1
+	IsRGB        Color = 1 << 24
+)
+
+// RGB returns the color with the given components.
+func RGB(r, g, b uint8) Color {
+	return IsRGB | Color(r)<<16 | Color(g)<<8 | Color(b)
+}
+
+// Attr describes how a cell is drawn.
+type Attr struct {
+	Fg, Bg    Color
+	Bold      bool
+	Italic    bool
+	Underline bool
+	Reverse   bool
+}
+
+var defaultAttr = Attr{Fg: DefaultColor, Bg: DefaultColor}
+
+// A Cell is a single character cell of the screen. The second cell
+// of a wide rune holds the rune 0.
+type Cell struct {
+	Rune rune
+	Attr
+}
+
+type cursor struct {
+	x, y     int
+	attr     Attr
+	wrapNext bool // the next rune wraps to the next line
+	origin   bool // origin mode: positions relative to the scroll region
+	charsets [2]bool
+	shift    int // selected charset
+}
+
+type parserState int
+
+const (
+	stateGround parserState = iota
+	stateEscape
+	stateCSI
+	stateOSC
+	stateString // DCS, SOS, PM and APC strings, which are ignored
+	stateStringEsc
+)
+
+// A Terminal is the screen of a terminal emulator.
+type Terminal struct {
+	cols, rows int
+	lines      [][]Cell
+	main, alt  [][]Cell
+	altScreen  bool
+
+	cur, saved  cursor
+	top, bottom int // scroll region
+	tabs        []bool
+
+	autowrap   bool
+	insert     bool
+	appCursor  bool
+	hideCursor bool
+	title      string
+
+	reply io.Writer
+
+	state   parserState
+	pending []byte // incomplete UTF-8 sequence
+	private rune   // private marker of a CSI sequence
+	inter   []rune // intermediate characters
+	params  []int
+	str     []rune // OSC string
+}
+
+// New returns a terminal of the given size in characters.
+// Replies to the queries of the program are written to reply.
+func New(cols, rows int, reply io.Writer) *Terminal {
+	if cols < 1 {
+		cols = 1
+	}
+	if rows < 1 {
+		rows = 1
+	}
+	t := &Terminal{cols: cols, rows: rows, reply: reply}
+	t.reset()
+	return t
+}
+
+func (t *Terminal) reset() {
+	t.main = newGrid(t.cols, t.rows)
+	t.alt = newGrid(t.cols, t.rows)
+	t.lines = t.main
+	t.altScreen = false
+	t.cur = cursor{attr: defaultAttr}
+	t.saved = t.cur
+	t.top, t.bottom = 0, t.rows-1
+	t.tabs = make([]bool, t.cols)
+	for x := 0; x < t.cols; x += 8 {
+		t.tabs[x] = true
+	}
+	t.autowrap = true
+	t.insert = false
+	t.appCursor = false
+	t.hideCursor = false
+	t.state = stateGround
+}
+
+func newGrid(cols, rows int) [][]Cell {
+	g := make([][]Cell, rows)
+	for y := range g {
+		g[y] = newLine(cols, defaultAttr)
+	}
+	return g
+}
+
+func newLine(cols int, attr Attr) []Cell {
+	l := make([]Cell, cols)
+	clearCells(l, attr)
+	return l
+}
+
+func clearCells(cells []Cell, attr Attr) {
+	blank := Cell{Rune: ' ', Attr: Attr{Fg: DefaultColor, Bg: attr.Bg}}
+	for i := range cells {
+		cells[i] = blank
+	}
+}
+
+// Size returns the size of the terminal in characters.
+func (t *Terminal) Size() (cols, rows int) { return t.cols, t.rows }
+
+// Cell returns the cell at the column x and the row y.
+func (t *Terminal) Cell(x, y int) Cell { return t.lines[y][x] }
+
+// Cursor returns the position of the cursor and whether it is visible.
+func (t *Terminal) Cursor() (x, y int, visible bool) {
+	return t.cur.x, t.cur.y, !t.hideCursor
+}
+
+// AppCursorKeys reports whether the cursor keys are to send
+// the application sequences (ESC O A) rather than the normal
+// ones (ESC [ A).
+func (t *Terminal) AppCursorKeys() bool { return t.appCursor }
+
+// Title returns the title set by the program.
+func (t *Terminal) Title() string { return t.title }
+
+// String returns the text of the screen without trailing spaces
+// and empty lines.
+func (t *Terminal) String() string {
+	var b strings.Builder
+	for _, l := range t.lines {
+		var s []rune
+		for _, c := range l {
+			if c.Rune != 0 {
+				s = append(s, c.Rune)
+			}
+		}
+		b.WriteString(strings.TrimRight(string(s), " "))
+		b.WriteByte('\n')
+	}
+	return strings.TrimRight(b.String(), "\n") + "\n"
+}
+
+// Resize changes the size of the terminal. The content of the screen
+// is kept in the top left corner unless the cursor would end up
+// below the screen, in which case the lines are scrolled up.
+func (t *Terminal) Resize(cols, rows int) {
+	if cols < 1 {
+		cols = 1
+	}
+	if rows < 1 {
+		rows = 1
+	}
+	if cols == t.cols && rows == t.rows {
+		return
+	}
+	shift := 0
+	if t.cur.y >= rows {
+		shift = t.cur.y - rows + 1
+	}
+	resize := func(g [][]Cell, shift int) [][]Cell {
+		ng := newGrid(cols, rows)
+		for y := range ng {
+			if y+shift < len(g) {
+				copy(ng[y], g[y+shift])
+			}
+		}
+		return ng
+	}
+	if t.altScreen {
+		t.alt = resize(t.alt, shift)
+		t.main = resize(t.main, 0)
+		t.lines = t.alt
+	} else {
+		t.main = resize(t.main, shift)
+		t.alt = resize(t.alt, 0)
+		t.lines = t.main
+	}
+	tabs := make([]bool, cols)
+	copy(tabs, t.tabs)
+	for x := len(t.tabs); x < cols; x++ {
+		tabs[x] = x%8 == 0
+	}
+	t.tabs = tabs
+	t.cols, t.rows = cols, rows
+	t.top, t.bottom = 0, rows-1
+	t.cur.y -= shift
+	t.cur.x = clamp(t.cur.x, 0, cols-1)
+	t.cur.wrapNext = false
+	t.saved.x = clamp(t.saved.x, 0, cols-1)
+	t.saved.y = clamp(t.saved.y, 0, rows-1)
+}
+
+func clamp(n, min, max int) int {
+	if n < min {
+		return min
+	}
+	if n > max {
+		return max
+	}
+	return n
+}
+
+// Write interprets b as the output of a program.
+func (t *Terminal) Write(b []byte) (n int, err error) {
+	n = len(b)
+	if len(t.pending) > 0 {
+		b = append(t.pending, b...)
+		t.pending = nil
+	}
+	for len(b) > 0 {
+		r, size := rune(b[0]), 1
+		if r >= utf8.RuneSelf {
+			if !utf8.FullRune(b) {
+				t.pending = append([]byte(nil), b...)
+				break
+			}
+			r, size = utf8.DecodeRune(b)
+		}
+		b = b[size:]
+		t.put(r)
+	}
+	return n, nil
+}
+
+func (t *Terminal) put(r rune) {
+	switch t.state {
+	case stateOSC:
+		switch r {
+		case 0x07:
+			t.osc()
+			t.state = stateGround
+		case 0x1b:
+			t.osc()
+			t.state = stateStringEsc
+		default:
+			t.str = append(t.str, r)
+		}
+		return
+	case stateString:
+		switch r {
+		case 0x07:
+			t.state = stateGround
+		case 0x1b:
+			t.state = stateStringEsc
+		}
+		return
+	case stateStringEsc:
+		// ESC \ terminates the string; anything else
+		// starts a new sequence.
+		t.state = stateGround
+		if r != '\\' {
+			t.put(0x1b)
+			t.put(r)
+		}
+		return
+	}
+
+	if r < 0x20 || r == 0x7f {
+		t.control(r)
+		return
+	}
+	switch t.state {
+	case stateGround:
+		t.print(r)
+	case stateEscape:
+		t.escape(r)
+	case stateCSI:
+		t.csiByte(r)
+	}
+}
+
+// control executes the control character r. Control characters
+// are executed even in the middle of escape sequences.
+func (t *Terminal) control(r rune) {
+	switch r {
+	case 0x1b:
+		t.state = stateEscape
+		t.inter = t.inter[:0]
+	case 0x18, 0x1a: // CAN, SUB
+		t.state = stateGround
+	case '\b':
+		if t.cur.x > 0 {
+			t.cur.x--
+		}
+		t.cur.wrapNext = false
+	case '\t':
+		t.tab(1)
+	case '\n', '\v', '\f':
+		t.lineFeed()
+	case '\r':
+		t.cur.x = 0
+		t.cur.wrapNext = false
+	case 0x0e: // SO
+		t.cur.shift = 1
+	case 0x0f: // SI
+		t.cur.shift = 0
+	}
+}
+
+func (t *Terminal) print(r rune) {
+	if t.cur.charsets[t.cur.shift] {
+		if g, ok := lineDrawing[r]; ok {
+			r = g
+		}
+	}
+	w := runewidth.RuneWidth(r)
+	if w == 0 || w > t.cols {
+		// Combining characters aren't supported.
+		return
+	}
+	if t.cur.wrapNext && t.autowrap {
+		t.cur.x = 0
+		t.lineFeed()
+	}
+	t.cur.wrapNext = false
+	if t.cur.x+w > t.cols {
+		if t.autowrap {
+			t.cur.x = 0
+			t.lineFeed()
+		} else {
+			t.cur.x = t.cols - w
+		}
+	}
+	line := t.lines[t.cur.y]
+	if t.insert {
+		copy(line[t.cur.x+w:], line[t.cur.x:])
+	}
+	line[t.cur.x] = Cell{Rune: r, Attr: t.cur.attr}
+	if w == 2 {
+		line[t.cur.x+1] = Cell{Rune: 0, Attr: t.cur.attr}
+	}
+	if t.cur.x+w >= t.cols {
+		t.cur.x = t.cols - 1
+		t.cur.wrapNext = true
+	} else {
+		t.cur.x += w
+	}
+}
+
+// lineDrawing maps ASCII to the DEC special graphics characters.
+var lineDrawing = map[rune]rune{
+	'`': '◆', 'a': '▒', 'f': '°', 'g': '±', 'j': '┘', 'k': '┐',
+	'l': '┌', 'm': '└', 'n': '┼', 'o': '⎺', 'p': '⎻', 'q': '─',
+	'r': '⎼', 's': '⎽', 't': '├', 'u': '┤', 'v': '┴', 'w': '┬',
+	'x': '│', 'y': '≤', 'z': '≥', '{': 'π', '|': '≠', '}': '£',
+	'~': '·',
+}
+
+func (t *Terminal) lineFeed() {
+	t.cur.wrapNext = false
+	if t.cur.y == t.bottom {
+		t.scrollUp(t.top, 1)
+	} else if t.cur.y < t.rows-1 {
+		t.cur.y++
+	}
+}
+
+func (t *Terminal) reverseIndex() {
+	t.cur.wrapNext = false
+	if t.cur.y == t.top {
+		t.scrollDown(t.top, 1)
+	} else if t.cur.y > 0 {
+		t.cur.y--
+	}
+}
+
+// scrollUp scrolls the lines from y to the bottom of the scroll
+// region up by n lines.
+func (t *Terminal) scrollUp(y, n int) {
+	region := t.lines[y : t.bottom+1]
+	n = clamp(n, 0, len(region))
+	copy(region, region[n:])
+	for i := len(region) - n; i < len(region); i++ {
+		region[i] = newLine(t.cols, t.cur.attr)
+	}
+}
+
+// scrollDown scrolls the lines from y to the bottom of the scroll
+// region down by n lines.
+func (t *Terminal) scrollDown(y, n int) {
+	region := t.lines[y : t.bottom+1]
+	n = clamp(n, 0, len(region))
+	copy(region[n:], region)
+	for i := 0; i < n; i++ {
+		region[i] = newLine(t.cols, t.cur.attr)
+	}
+}
+
+func (t *Terminal) tab(n int) {
+	for ; n > 0 && t.cur.x < t.cols-1; n-- {
+		t.cur.x++
+		for t.cur.x < t.cols-1 && !t.tabs[t.cur.x] {
+			t.cur.x++
+		}
+	}
+	for ; n < 0 && t.cur.x > 0; n++ {
+		t.cur.x--
+		for t.cur.x > 0 && !t.tabs[t.cur.x] {
+			t.cur.x--
+		}
+	}
+}
+
+// moveTo moves the cursor to x, y, which are relative to the scroll
+// region in the origin mode.
+func (t *Terminal) moveTo(x, y int) {
+	minY, maxY := 0, t.rows-1
+	if t.cur.origin {
+		y += t.top
+		minY, maxY = t.top, t.bottom
+	}
+	t.cur.x = clamp(x, 0, t.cols-1)
+	t.cur.y = clamp(y, minY, maxY)
+	t.cur.wrapNext = false
+}
+
+// moveBy moves the cursor vertically within the scroll region if
+// it's in it, otherwise within the screen.
+func (t *Terminal) moveBy(dx, dy int) {
+	minY, maxY := 0, t.rows-1
+	if t.cur.y >= t.top && t.cur.y <= t.bottom {
+		minY, maxY = t.top, t.bottom
+	}
+	t.cur.x = clamp(t.cur.x+dx, 0, t.cols-1)
+	t.cur.y = clamp(t.cur.y+dy, minY, maxY)
+	t.cur.wrapNext = false
+}
+
+func (t *Terminal) escape(r rune) {
+	if r >= 0x20 && r <= 0x2f {
+		t.inter = append(t.inter, r)
+		return
+	}
+	t.state = stateGround
+	if len(t.inter) > 0 {
+		t.designate(t.inter[0], r)
+		return
+	}
+	switch r {
+	case '[':
+		t.state = stateCSI
+		t.private = 0
+		t.params = t.params[:0]
+	case ']':
+		t.state = stateOSC
+		t.str = t.str[:0]
+	case 'P', 'X', '^', '_':
+		t.state = stateString
+	case '7':
+		t.saved = t.cur
+	case '8':
+		t.cur = t.saved
+	case 'D':
+		t.lineFeed()
+	case 'E':
+		t.cur.x = 0
+		t.lineFeed()
+	case 'M':
+		t.reverseIndex()
+	case 'H':
+		t.tabs[t.cur.x] = true
+	case 'c':
+		t.reset()
+	}
+}
+
+// designate handles the escape sequences with the intermediate
+// character i.
+func (t *Terminal) designate(i, r rune) {
+	switch i {
+	case '(', ')':
+		g := 0
+		if i == ')' {
+			g = 1
+		}
+		t.cur.charsets[g] = r == '0'
+	case '#':
+		if r == '8' {
+			// DECALN: fill the screen with Es.
+			for _, l := range t.lines {
+				for x := range l {
+					l[x] = Cell{Rune: 'E', Attr: defaultAttr}
+				}
+			}
+		}
+	}
+}
+
+func (t *Terminal) csiByte(r rune) {
+	switch {
+	case r >= '0' && r <= '9':
+		if len(t.params) == 0 {
+			t.params = append(t.params, 0)
+		}
+		p := &t.params[len(t.params)-1]
+		if *p < 1<<16 {
+			*p = *p*10 + int(r-'0')
+		}
+	case r == ';' || r == ':':
+		if len(t.params) == 0 {
+			t.params = append(t.params, 0)
+		}
+		t.params = append(t.params, 0)
+	case r >= '<' && r <= '?':
+		t.private = r
+	case r >= 0x20 && r <= 0x2f:
+		t.inter = append(t.inter, r)
+	case r >= 0x40 && r <= 0x7e:
+		t.state = stateGround
+		if len(t.inter) == 0 {
+			t.csi(r)
+		}
+	default:
+		t.state = stateGround
+	}
+}
+
+// param returns the i-th parameter, or def if it's missing or zero.
+func (t *Terminal) param(i, def int) int {
+	if i >= len(t.params) || t.params[i] == 0 {
+		return def
+	}
+	return t.params[i]
+}
+
+func (t *Terminal) csi(r rune) {
+	if t.private != 0 {
+		switch {
+		case r == 'h' || r == 'l':
+			if t.private == '?' {
+				for _, p := range t.params {
+					t.setPrivateMode(p, r == 'h')
+				}
+			}
+		case r == 'c' && t.private == '>':
+			t.respond("\x1b[>0;0;0c")
+		}
+		return
+	}
+
+	n := t.param(0, 1)
+	switch r {
+	case '@':
+		line := t.lines[t.cur.y][t.cur.x:]
+		n = clamp(n, 0, len(line))
+		copy(line[n:], line)
+		clearCells(line[:n], t.cur.attr)
+	case 'A':
+		t.moveBy(0, -n)
+	case 'B', 'e':
+		t.moveBy(0, n)
+	case 'C', 'a':
+		t.moveBy(n, 0)
+	case 'D':
+		t.moveBy(-n, 0)
+	case 'E':
+		t.moveBy(0, n)
+		t.cur.x = 0
+	case 'F':
+		t.moveBy(0, -n)
+		t.cur.x = 0
+	case 'G', '`':
+		t.cur.x = clamp(n-1, 0, t.cols-1)
+		t.cur.wrapNext = false
+	case 'H', 'f':
+		t.moveTo(t.param(1, 1)-1, n-1)
+	case 'd':
+		x := t.cur.x
+		t.moveTo(x, n-1)
+	case 'I':
+		t.tab(n)
+	case 'Z':
+		t.tab(-n)
+	case 'J':
+		t.eraseDisplay(t.param(0, 0))
+	case 'K':
+		t.eraseLine(t.param(0, 0))
+	case 'L', 'M':
+		if t.cur.y < t.top || t.cur.y > t.bottom {
+			break
+		}
+		if r == 'L' {
+			t.scrollDown(t.cur.y, n)
+		} else {
+			t.scrollUp(t.cur.y, n)
+		}
+		t.cur.x = 0
+		t.cur.wrapNext = false
+	case 'P':
+		line := t.lines[t.cur.y][t.cur.x:]
+		n = clamp(n, 0, len(line))
+		copy(line, line[n:])
+		clearCells(line[len(line)-n:], t.cur.attr)
+	case 'X':
+		line := t.lines[t.cur.y][t.cur.x:]
+		clearCells(line[:clamp(n, 0, len(line))], t.cur.attr)
+	case 'S':
+		t.scrollUp(t.top, n)
+	case 'T':
+		t.scrollDown(t.top, n)
+	case 'g':
+		switch t.param(0, 0) {
+		case 0:
+			t.tabs[t.cur.x] = false
+		case 3:
+			for x := range t.tabs {
+				t.tabs[x] = false
+			}
+		}
+	case 'h', 'l':
+		for _, p := range t.params {
+			if p == 4 {
+				t.insert = r == 'h'
+			}
+		}
+	case 'm':
+		t.sgr()
+	case 'n':
+		switch t.param(0, 0) {
+		case 5:
+			t.respond("\x1b[0n")
+		case 6:
+			y := t.cur.y
+			if t.cur.origin {
+				y -= t.top
+			}
+			t.respond(fmt.Sprintf("\x1b[%d;%dR", y+1, t.cur.x+1))
+		}
+	case 'c':
+		if t.param(0, 0) == 0 {
+			t.respond("\x1b[?1;2c")
+		}
+	case 'r':
+		top, bottom := t.param(0, 1)-1, t.param(1, t.rows)-1
+		if bottom >= t.rows {
+			bottom = t.rows - 1
+		}
+		if top < bottom {
+			t.top, t.bottom = top, bottom
+			t.moveTo(0, 0)
+		}
+	case 's':
+		t.saved = t.cur
+	case 'u':
+		t.cur = t.saved
+	}
+}
+
+func (t *Terminal) setPrivateMode(mode int, on bool) {
+	switch mode {
+	case 1:
+		t.appCursor = on
+	case 6:
+		t.cur.origin = on
+		t.moveTo(0, 0)
+	case 7:
+		t.autowrap = on
+	case 25:
+		t.hideCursor = !on
+	case 47, 1047, 1049:
+		if on == t.altScreen {
+			return
+		}
+		if mode == 1049 && on {
+			t.saved = t.cur
+		}
+		t.altScreen = on
+		t.lines = t.main
+		if on {
+			t.lines = t.alt
+			if mode != 47 {
+				for _, l := range t.alt {
+					clearCells(l, defaultAttr)
+				}
+			}
+		}
+		if mode == 1049 && !on {
+			t.cur = t.saved
+		}
+	}
+}
+
+func (t *Terminal) eraseDisplay(mode int) {
+	switch mode {
+	case 0:
+		t.eraseLine(0)
+		for _, l := range t.lines[t.cur.y+1:] {
+			clearCells(l, t.cur.attr)
+		}
+	case 1:
+		t.eraseLine(1)
+		for _, l := range t.lines[:t.cur.y] {
+			clearCells(l, t.cur.attr)
+		}
+	case 2:
+		for _, l := range t.lines {
+			clearCells(l, t.cur.attr)
+		}
+	}
+}
+
+func (t *Terminal) eraseLine(mode int) {
+	line := t.lines[t.cur.y]
+	switch mode {
+	case 0:
+		clearCells(line[t.cur.x:], t.cur.attr)
+	case 1:
+		clearCells(line[:t.cur.x+1], t.cur.attr)
+	case 2:
+		clearCells(line, t.cur.attr)
+	}
+	t.cur.wrapNext = false
+}
+
+// sgr sets the attributes of the following characters.
+func (t *Terminal) sgr() {
+	params := t.params
+	if len(params) == 0 {
+		params = []int{0}
+	}
+	a := &t.cur.attr
+	for i := 0; i < len(params); i++ {
+		switch p := params[i]; {
+		case p == 0:
+			*a = defaultAttr
+		case p == 1:
+			a.Bold = true
+		case p == 3:
+			a.Italic = true
+		case p == 4:
+			a.Underline = true
+		case p == 7:
+			a.Reverse = true
+		case p == 22:
+			a.Bold = false
+		case p == 23:
+			a.Italic = false
+		case p == 24:
+			a.Underline = false
+		case p == 27:
+			a.Reverse = false
+		case p >= 30 && p <= 37:
+			a.Fg = Color(p - 30)
+		case p >= 40 && p <= 47:
+			a.Bg = Color(p - 40)
+		case p >= 90 && p <= 97:
+			a.Fg = Color(p - 90 + 8)
+		case p >= 100 && p <= 107:
+			a.Bg = Color(p - 100 + 8)
+		case p == 39:
+			a.Fg = DefaultColor
+		case p == 49:
+			a.Bg = DefaultColor
+		case p == 38 || p == 48:
+			c, n := extendedColor(params[i+1:])
+			i += n
+			if c == DefaultColor {
+				break
+			}
+			if p == 38 {
+				a.Fg = c
+			} else {
+				a.Bg = c
+			}
+		}
+	}
+}
+
+// extendedColor parses the color of the sequences 38 and 48, either
+// 5;n or 2;r;g;b. It returns the color and the number of parameters
+// consumed.
+func extendedColor(params []int) (Color, int) {
+	if len(params) == 0 {
+		return DefaultColor, 0
+	}
+	switch params[0] {
+	case 5:
+		if len(params) < 2 {
+			return DefaultColor, len(params)
+		}
+		return Color(params[1] & 0xff), 2
+	case 2:
+		if len(params) < 4 {
+			return DefaultColor, len(params)
+		}
+		return RGB(uint8(params[1]), uint8(params[2]), uint8(params[3])), 4
+	}
+	return DefaultColor, 1
+}
+
+// osc handles an operating system command; only the setting
+// of the window title is supported.
+func (t *Terminal) osc() {
+	s := string(t.str)
+	i := strings.IndexByte(s, ';')
+	if i < 0 {
+		return
+	}
+	switch s[:i] {
+	case "0", "2":
+		t.title = s[i+1:]
+	}
+}
+
+func (t *Terminal) respond(s string) {
+	if t.reply != nil {
+		io.WriteString(t.reply, s)
+	}
+}
@@ -0,0 +1,124 @@
+package vt
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestScreen(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"text", "hello\r\nworld", "hello\nworld\n"},
+		{"wrap", "abcdefghij", "abcdefgh\nij\n"},
+		{"cr", "abcdefgh\rX", "Xbcdefgh\n"},
+		{"backspace", "abc\b\bX", "aXc\n"},
+		{"tab", "a\tb\tc", "a      b\nc\n"},
+		{"scroll", "1\r\n2\r\n3\r\n4\r\n5", "2\n3\n4\n5\n"},
+		{"cup", "\x1b[2;3Hx\x1b[Hy", "y\n  x\n"},
+		{"cursor moves", "\x1b[3Bab\x1b[2D\x1b[Ac", "\n\nc\nab\n"},
+		{"erase line", "abcdef\x1b[3D\x1b[K", "abc\n"},
+		{"erase to cursor", "abcdef\x1b[3D\x1b[1K", "    ef\n"},
+		{"erase display", "ab\r\ncd\x1b[2J", "\n"},
+		{"erase below", "ab\r\ncd\r\nef\x1b[2;2H\x1b[J", "ab\nc\n"},
+		{"insert chars", "abcd\x1b[3G\x1b[2@", "ab  cd\n"},
+		{"delete chars", "abcdef\x1b[2G\x1b[2P", "adef\n"},
+		{"erase chars", "abcdef\x1b[2G\x1b[2X", "a  def\n"},
+		{"insert lines", "1\r\n2\r\n3\x1b[2H\x1b[L", "1\n\n2\n3\n"},
+		{"delete lines", "1\r\n2\r\n3\x1b[1H\x1b[M", "2\n3\n"},
+		{"scroll region", "1\r\n2\r\n3\r\n4\x1b[2;3r\x1b[3Hx\nY", "1\nx\n Y\n4\n"},
+		{"reverse index", "1\r\n2\x1b[H\x1bMx", "x\n1\n2\n"},
+		{"save cursor", "ab\x1b7\x1b[3;3Hc\x1b8d", "abd\n\n  c\n"},
+		{"line drawing", "\x1b(0lqk\x1b(Bq", "┌─┐q\n"},
+		{"utf-8", "žluťoučký", "žluťoučk\ný\n"},
+		{"wide", "ab世界", "ab世界\n"},
+		{"osc", "\x1b]0;title\x07ok", "ok\n"},
+		{"dcs", "\x1bPq#0;2;0;0;0\x1b\\ok", "ok\n"},
+		{"no autowrap", "\x1b[?7labcdefghij", "abcdefgj\n"},
+		{"alt screen", "main\x1b[?1049halt\x1b[?1049lx", "mainx\n"},
+	}
+	for _, tt := range tests {
+		term := New(8, 4, nil)
+		term.Write([]byte(tt.in))
+		if got := term.String(); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSplitWrites(t *testing.T) {
+	in := []byte("\x1b[1;31mž\x1b]2;x\x07\x1b[0m!")
+	term := New(8, 2, nil)
+	for i := range in {
+		term.Write(in[i : i+1])
+	}
+	if got, want := term.String(), "ž!\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if c := term.Cell(0, 0); c.Fg != 1 || !c.Bold {
+		t.Errorf("got %+v, want bold red", c)
+	}
+	if got := term.Title(); got != "x" {
+		t.Errorf("got title %q, want x", got)
+	}
+}
+
+func TestAttributes(t *testing.T) {
+	term := New(10, 1, nil)
+	term.Write([]byte("a\x1b[4;7;32;45mb\x1b[38;5;200;48;2;1;2;3mc\x1b[24;27;39;49md\x1b[93;104me"))
+	tests := []Attr{
+		defaultAttr,
+		{Fg: 2, Bg: 5, Underline: true, Reverse: true},
+		{Fg: 200, Bg: RGB(1, 2, 3), Underline: true, Reverse: true},
+		defaultAttr,
+		{Fg: 11, Bg: 12},
+	}
+	for x, want := range tests {
+		if got := term.Cell(x, 0).Attr; got != want {
+			t.Errorf("cell %d: got %+v, want %+v", x, got, want)
+		}
+	}
+}
+
+func TestReplies(t *testing.T) {
+	var reply bytes.Buffer
+	term := New(10, 5, &reply)
+	term.Write([]byte("\x1b[3;4H\x1b[6n\x1b[c"))
+	if got, want := reply.String(), "\x1b[3;4R\x1b[?1;2c"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestModes(t *testing.T) {
+	term := New(10, 5, nil)
+	term.Write([]byte("\x1b[?1h\x1b[?25l"))
+	if !term.AppCursorKeys() {
+		t.Error("application cursor keys not set")
+	}
+	if _, _, visible := term.Cursor(); visible {
+		t.Error("cursor not hidden")
+	}
+	term.Write([]byte("\x1bc"))
+	if term.AppCursorKeys() {
+		t.Error("application cursor keys not reset")
+	}
+}
+
+func TestResize(t *testing.T) {
+	term := New(6, 4, nil)
+	term.Write([]byte("1\r\n2\r\n3\r\n4abcde"))
+	term.Resize(3, 2)
+	if got, want := term.String(), "3\n4ab\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if x, y, _ := term.Cursor(); x != 2 || y != 1 {
+		t.Errorf("got cursor at %d,%d, want 2,1", x, y)
+	}
+	term.Resize(5, 3)
+	term.Write([]byte("\r\nxyz\t!"))
+	if got, want := term.String(), "3\n4ab\nxyz !\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}